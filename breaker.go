@@ -0,0 +1,74 @@
+package goodroutine
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker implements a circuit breaker on top of a HealthChecker, whose up / down state is the closed / open state.
+// Outcomes of calls to the dependency are reported with ReportSuccess and ReportFailure, and feed the thresholds:
+// thresholdDown consecutive failures open the breaker, after which Allow refuses calls for OpenTimeout.
+// The breaker is then half-open: Allow lets a single trial call through at a time, until it is reported.
+// A failed trial reopens the breaker for OpenTimeout, thresholdUp consecutive successful trials close it.
+type Breaker struct {
+	mu        sync.Mutex
+	openUntil time.Time
+	trial     bool
+
+	// OpenTimeout is the duration calls are refused after a failure while open, before a trial is allowed
+	OpenTimeout time.Duration
+
+	*HealthChecker
+}
+
+// NewBreaker creates a new closed Breaker.
+// A typical usage is a thresholdUp of 2, thresholdDown of 5 and openTimeout of 30sec.
+func NewBreaker(thresholdUp int, thresholdDown int, openTimeout time.Duration) *Breaker {
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return nil
+	}), true, thresholdUp, thresholdDown)
+	hc.FastStart = false
+	return &Breaker{
+		OpenTimeout:   openTimeout,
+		HealthChecker: hc,
+	}
+}
+
+// Allow returns true if a call may proceed: always while closed, never while open,
+// and for a single trial at a time while half-open.
+func (b *Breaker) Allow() bool {
+	if b.IsUp() {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// ReportSuccess records a successful call.
+func (b *Breaker) ReportSuccess() {
+	b.report(nil)
+}
+
+// ReportFailure records a failed call, err is recorded as the last error, ErrUnhealthy if nil.
+// Errors are subject to IsFailure like for any health check.
+func (b *Breaker) ReportFailure(err error) {
+	if err == nil {
+		err = ErrUnhealthy
+	}
+	b.report(err)
+}
+
+func (b *Breaker) report(err error) {
+	b.HealthChecker.record(probe{err: err})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !b.IsUp() && b.isFailure(err) {
+		b.openUntil = time.Now().Add(b.OpenTimeout)
+	}
+}
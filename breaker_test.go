@@ -0,0 +1,50 @@
+package goodroutine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	timeout := 5 * time.Millisecond
+	b := NewBreaker(2, 2, timeout)
+	if !b.Allow() {
+		t.Error("closed breaker should allow")
+	}
+
+	// open
+	b.ReportFailure(errors.New("error"))
+	b.ReportFailure(errors.New("error"))
+	if b.IsUp() || b.Allow() {
+		t.Error("open breaker should not allow")
+	}
+
+	// half-open, single trial which fails
+	time.Sleep(timeout)
+	if !b.Allow() {
+		t.Error("half-open breaker should allow a trial")
+	}
+	if b.Allow() {
+		t.Error("half-open breaker should allow a single trial")
+	}
+	b.ReportFailure(nil)
+	if b.Allow() {
+		t.Error("failed trial should reopen the breaker")
+	}
+
+	// trials succeed up to thresholdUp
+	time.Sleep(timeout)
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Errorf("trial %d should be allowed", i)
+		}
+		b.ReportSuccess()
+	}
+	if !b.IsUp() {
+		t.Error("breaker should be closed")
+	}
+	if !b.Allow() || !b.Allow() {
+		t.Error("closed breaker should allow")
+	}
+}
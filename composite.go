@@ -0,0 +1,106 @@
+package goodroutine
+
+import "sync"
+
+// CompositeMode is the rule deciding the state of a CompositeHealthChecker from its children.
+type CompositeMode struct {
+	all    bool
+	quorum int
+}
+
+var (
+	// ModeAll is up if all children are up
+	ModeAll = CompositeMode{all: true}
+	// ModeAny is up if at least one child is up
+	ModeAny = CompositeMode{quorum: 1}
+)
+
+// Quorum returns a mode that is up if at least k children are up.
+func Quorum(k int) CompositeMode {
+	return CompositeMode{quorum: k}
+}
+
+// required returns the number of children that must be up, out of n.
+func (cm CompositeMode) required(n int) int {
+	if cm.all {
+		return n
+	}
+	return cm.quorum
+}
+
+// CompositeHealthChecker aggregates the state of several HealthChecker, e.g. for a cluster health view.
+// It reacts to the transitions of its children, it does not run any check itself.
+type CompositeHealthChecker struct {
+	mu       sync.Mutex
+	mode     CompositeMode
+	children []*HealthChecker
+	up       bool
+
+	// OnUp is called when the aggregate state changes to up, numUp is the number of children up
+	OnUp func(numUp int)
+	// OnDown is called when the aggregate state changes to down, numUp is the number of children up
+	OnDown func(numUp int)
+}
+
+// NewCompositeHealthChecker creates a new CompositeHealthChecker aggregating children according to mode,
+// e.g. Quorum(2) for 2 out of 3 children.
+func NewCompositeHealthChecker(mode CompositeMode, children ...*HealthChecker) *CompositeHealthChecker {
+	chc := &CompositeHealthChecker{
+		mode:     mode,
+		children: children,
+	}
+	chc.up = chc.UpCount() >= mode.required(len(children))
+	for _, child := range children {
+		child.subscribe(func(up bool) {
+			chc.update()
+		})
+	}
+	return chc
+}
+
+// update recomputes the state after a child transition.
+func (chc *CompositeHealthChecker) update() {
+	chc.mu.Lock()
+	numUp := chc.UpCount()
+	up := numUp >= chc.mode.required(len(chc.children))
+	changed := up != chc.up
+	chc.up = up
+	chc.mu.Unlock()
+	if !changed {
+		return
+	}
+	if up && chc.OnUp != nil {
+		chc.OnUp(numUp)
+	} else if !up && chc.OnDown != nil {
+		chc.OnDown(numUp)
+	}
+}
+
+// IsUp returns true if enough children are up according to the mode.
+func (chc *CompositeHealthChecker) IsUp() bool {
+	chc.mu.Lock()
+	defer chc.mu.Unlock()
+	return chc.up
+}
+
+// UpCount returns the number of children up.
+func (chc *CompositeHealthChecker) UpCount() int {
+	n := 0
+	for _, child := range chc.children {
+		if child.IsUp() {
+			n++
+		}
+	}
+	return n
+}
+
+// DownChildren returns the children that are down.
+func (chc *CompositeHealthChecker) DownChildren() []*HealthChecker {
+	var down []*HealthChecker
+	for _, child := range chc.children {
+		if !child.IsUp() {
+			down = append(down, child)
+		}
+	}
+	return down
+}
@@ -0,0 +1,51 @@
+package goodroutine
+
+import "testing"
+
+func TestCompositeQuorum(t *testing.T) {
+	var children []*HealthChecker
+	for i := 0; i < 3; i++ {
+		children = append(children, NewHealthChecker(nil, true, 1, 1))
+	}
+	chc := NewCompositeHealthChecker(Quorum(2), children...)
+	var transitions []bool
+	chc.OnUp = func(numUp int) {
+		transitions = append(transitions, true)
+	}
+	chc.OnDown = func(numUp int) {
+		transitions = append(transitions, false)
+	}
+	if !chc.IsUp() {
+		t.Error("composite should be up")
+	}
+
+	children[0].ForceState(false)
+	if !chc.IsUp() || chc.UpCount() != 2 {
+		t.Errorf("composite should be up with 2 of 3, got=%v", chc.UpCount())
+	}
+	children[1].ForceState(false)
+	if chc.IsUp() {
+		t.Error("composite should be down with 1 of 3")
+	}
+	if down := chc.DownChildren(); len(down) != 2 || down[0] != children[0] || down[1] != children[1] {
+		t.Errorf("Incorrect down children, got=%v", down)
+	}
+	children[0].ClearForcedState()
+	if !chc.IsUp() {
+		t.Error("composite should be up again")
+	}
+	if len(transitions) != 2 || transitions[0] || !transitions[1] {
+		t.Errorf("Incorrect transitions, got=%v", transitions)
+	}
+}
+
+func TestCompositeAllAny(t *testing.T) {
+	a := NewHealthChecker(nil, true, 1, 1)
+	b := NewHealthChecker(nil, false, 1, 1)
+	if NewCompositeHealthChecker(ModeAll, a, b).IsUp() {
+		t.Error("all should be down")
+	}
+	if !NewCompositeHealthChecker(ModeAny, a, b).IsUp() {
+		t.Error("any should be up")
+	}
+}
@@ -0,0 +1,70 @@
+package goodroutine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrentRoutine implements an interval routine that runs the function concurrently, up to a maximum.
+// Each run interval, retry or trigger dispatches a run to a new worker goroutine, if fewer than
+// maxConcurrency workers are active, otherwise the run is skipped and counted in SkippedRunCount.
+// Runs may complete in any order, panics are recovered in each worker like in IntervalRoutine.
+// Each completed run is recorded and scheduled like a run of IntervalRoutine, from the routine goroutine:
+// its outcome drives LastErr, Results, OnEvent, OnSlowRun, the retry backoff, RunOnceMode, MaxRetries
+// and MaxConsecutivePanics, and the next dispatch is armed from its completion.
+// The routine goroutine exits once all workers have, so Done, StopAndWait and Run wait for them,
+// and a final run, see RunOnStop and StopAndRunFinal, happens after them.
+type ConcurrentRoutine struct {
+	f      func() error
+	sem    chan struct{}
+	active int32
+
+	IntervalRoutine
+}
+
+// NewConcurrentRoutine creates a new ConcurrentRoutine, which takes care of running f() concurrently.
+// Intervals are equivalent to IntervalRoutine, maxConcurrency is the maximum number of concurrent runs, at least 1.
+func NewConcurrentRoutine(f func() error, runInterval time.Duration, retryInterval time.Duration, maxConcurrency int) *ConcurrentRoutine {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	cr := &ConcurrentRoutine{
+		f:   f,
+		sem: make(chan struct{}, maxConcurrency),
+	}
+	cr.IntervalRoutine.init(RunnerFunc(f), runInterval, retryInterval)
+	cr.IntervalRoutine.dispatch = cr.dispatch
+	cr.IntervalRoutine.completed = make(chan runOutcome)
+	return cr
+}
+
+// IsRunning returns true while any worker is running the function.
+func (cr *ConcurrentRoutine) IsRunning() bool {
+	return atomic.LoadInt32(&cr.active) > 0
+}
+
+// dispatch starts a run on a worker, its outcome is sent to the routine goroutine.
+func (cr *ConcurrentRoutine) dispatch() {
+	select {
+	case cr.sem <- struct{}{}:
+	default:
+		// all workers busy
+		cr.IntervalRoutine.mu.Lock()
+		cr.IntervalRoutine.skipped++
+		cr.IntervalRoutine.mu.Unlock()
+		return
+	}
+
+	atomic.AddInt32(&cr.active, 1)
+	// workers are only added from the routine goroutine
+	cr.IntervalRoutine.workers.Add(1)
+	go func() {
+		defer cr.IntervalRoutine.workers.Done()
+		start := time.Now()
+		panicked, err := cr.IntervalRoutine.run(RunnerFunc(cr.f))
+		d := time.Since(start)
+		atomic.AddInt32(&cr.active, -1)
+		<-cr.sem
+		cr.IntervalRoutine.completed <- runOutcome{start: start, d: d, panicked: panicked, err: err}
+	}()
+}
@@ -0,0 +1,198 @@
+package goodroutine
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentRoutine(t *testing.T) {
+	var active, maxActive int32
+	barrier := make(chan bool)
+	f := func() error {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		<-barrier
+		atomic.AddInt32(&active, -1)
+		return nil
+	}
+	cr := NewConcurrentRoutine(f, 0, 0, 3)
+	cr.Start()
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(5 * time.Millisecond)
+		cr.TriggerRun()
+	}
+	time.Sleep(5 * time.Millisecond)
+	if g, w := atomic.LoadInt32(&maxActive), int32(3); g != w {
+		t.Errorf("Incorrect concurrency, got=%v, want=%v", g, w)
+	}
+	if !cr.IsRunning() {
+		t.Error("should be running")
+	}
+	if g, w := cr.SkippedRunCount(), int64(3); g != w {
+		t.Errorf("Incorrect skipped runs, got=%v, want=%v", g, w)
+	}
+
+	// done waits for workers
+	cr.Stop()
+	select {
+	case <-cr.Done():
+		t.Error("done before workers finished")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+	close(barrier)
+	select {
+	case <-cr.Done():
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("routine did not exit")
+	}
+	if cr.IsRunning() {
+		t.Error("should not be running")
+	}
+}
+
+func TestConcurrentRoutineStopBeforeStart(t *testing.T) {
+	cr := NewConcurrentRoutine(func() error {
+		return nil
+	}, time.Hour, 0, 2)
+	cr.Stop()
+	if cr.Start() {
+		t.Error("Start after Stop should be a no-op")
+	}
+	select {
+	case <-cr.Done():
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("Done should be closed")
+	}
+}
+
+func TestConcurrentRoutineRunWaitsForWorkers(t *testing.T) {
+	var finished int32
+	started := make(chan bool, 1)
+	cr := NewConcurrentRoutine(func() error {
+		started <- true
+		time.Sleep(30 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		return nil
+	}, time.Hour, 0, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	cr.Run(ctx)
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("Run should wait for workers")
+	}
+	select {
+	case <-cr.Done():
+	default:
+		t.Error("Done should be closed after Run")
+	}
+
+	cr = NewConcurrentRoutine(func() error {
+		started <- true
+		time.Sleep(30 * time.Millisecond)
+		atomic.StoreInt32(&finished, 2)
+		return nil
+	}, time.Hour, 0, 2)
+	cr.Start()
+	<-started
+	if err := cr.StopAndWait(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&finished) != 2 {
+		t.Error("StopAndWait should wait for workers")
+	}
+}
+
+func TestConcurrentRoutineRunOnceMode(t *testing.T) {
+	runErr := errors.New("error")
+	var calls int32
+	cr := NewConcurrentRoutine(func() error {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return runErr
+		}
+		return nil
+	}, time.Hour, time.Millisecond, 2)
+	cr.RunOnceMode = true
+	errs := make(chan error, 3)
+	cr.OnEvent = func(ev Event) {
+		errs <- ev.Err
+	}
+	cr.Start()
+	select {
+	case <-cr.Done():
+	case <-time.Tick(50 * time.Millisecond):
+		t.Fatal("routine did not stop")
+	}
+	if g, w := atomic.LoadInt32(&calls), int32(3); g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+	if cr.LastErr() != nil {
+		t.Errorf("Last run should succeed, got=%v", cr.LastErr())
+	}
+	for _, w := range []error{runErr, runErr, nil} {
+		if g := <-errs; g != w {
+			t.Errorf("Incorrect event error, got=%v, want=%v", g, w)
+		}
+	}
+}
+
+func TestConcurrentRoutineMaxConsecutivePanics(t *testing.T) {
+	limit := make(chan int, 1)
+	cr := NewConcurrentRoutine(func() error {
+		panic("blah")
+	}, time.Hour, time.Millisecond, 2)
+	cr.OnPanic = func(recovered interface{}) {}
+	cr.OnPanicLimit = func(numPanics int) {
+		limit <- numPanics
+	}
+	cr.MaxConsecutivePanics = 2
+	cr.Start()
+	defer cr.Stop()
+
+	select {
+	case n := <-limit:
+		if g, w := n, 2; g != w {
+			t.Errorf("Incorrect param, got=%v, want=%v", g, w)
+		}
+	case <-time.Tick(50 * time.Millisecond):
+		t.Fatal("limit callback was not called")
+	}
+	<-cr.Done()
+	if _, ok := cr.LastErr().(*PanicError); !ok {
+		t.Errorf("Last error should be a PanicError, got=%v", cr.LastErr())
+	}
+}
+
+func TestConcurrentRoutineOnSlowRun(t *testing.T) {
+	slow := make(chan time.Duration, 1)
+	cr := NewConcurrentRoutine(func() error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}, time.Hour, 0, 2)
+	cr.SlowRunThreshold = time.Millisecond
+	cr.OnSlowRun = func(d time.Duration) {
+		slow <- d
+	}
+	cr.Start()
+	defer cr.Stop()
+
+	select {
+	case d := <-slow:
+		if d < 5*time.Millisecond {
+			t.Errorf("Incorrect duration, got=%v, want>=%v", d, 5*time.Millisecond)
+		}
+	case <-time.Tick(50 * time.Millisecond):
+		t.Error("OnSlowRun was not called")
+	}
+}
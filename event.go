@@ -0,0 +1,93 @@
+package goodroutine
+
+import (
+	"errors"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventUp is a transition of a health check to up
+	EventUp EventType = iota + 1
+	// EventDown is a transition of a health check to down
+	EventDown
+	// EventPanic is a run that panicked
+	EventPanic
+	// EventRunError is a run that returned an error
+	EventRunError
+	// EventRunOK is a successful run
+	EventRunOK
+)
+
+func (et EventType) String() string {
+	switch et {
+	case EventUp:
+		return "up"
+	case EventDown:
+		return "down"
+	case EventPanic:
+		return "panic"
+	case EventRunError:
+		return "run_error"
+	case EventRunOK:
+		return "run_ok"
+	}
+	return "unknown"
+}
+
+// ScheduleReason is the reason of a scheduling decision, see IntervalRoutine.OnSchedule.
+type ScheduleReason int
+
+const (
+	// ScheduleTimer is a run starting because its interval elapsed
+	ScheduleTimer ScheduleReason = iota + 1
+	// ScheduleForce is a run starting because of a trigger, or the next run scheduled right away by ErrRunAgain
+	ScheduleForce
+	// ScheduleNormal is the next run scheduled at the normal interval
+	ScheduleNormal
+	// ScheduleRetryBackoff is the next run scheduled at the retry interval after an error, including backoff
+	ScheduleRetryBackoff
+)
+
+func (sr ScheduleReason) String() string {
+	switch sr {
+	case ScheduleTimer:
+		return "timer"
+	case ScheduleForce:
+		return "force"
+	case ScheduleNormal:
+		return "normal"
+	case ScheduleRetryBackoff:
+		return "retry_backoff"
+	}
+	return "unknown"
+}
+
+// Event describes a run outcome or a state transition, e.g. for audit logging.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Name is the name of the routine or health check
+	Name string
+	// Ups and Downs are the counts of a health check at the time of the event
+	Ups   int
+	Downs int
+	// Err is the error of the run, or the last error for EventDown
+	Err error
+	// FastStart is true for an EventUp or EventDown caused by fast start on the first run of a health check
+	FastStart bool
+}
+
+// runEventType returns the type of event for the outcome of a run.
+func runEventType(err error) EventType {
+	var pe *PanicError
+	switch {
+	case err == nil:
+		return EventRunOK
+	case errors.As(err, &pe):
+		return EventPanic
+	}
+	return EventRunError
+}
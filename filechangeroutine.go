@@ -1,46 +1,246 @@
 package goodroutine
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
+// FileChange describes a change detected on a file.
+// Stat is the new file info, or nil if Err is set.
+type FileChange struct {
+	File string
+	Stat os.FileInfo
+	Err  error
+}
+
+// FileAttributes is a set of file attributes compared to detect a file change.
+type FileAttributes int
+
+const (
+	// WatchModTime compares the modification time
+	WatchModTime FileAttributes = 1 << iota
+	// WatchSize compares the size
+	WatchSize
+	// WatchMode compares the mode and permission bits
+	WatchMode
+	// WatchOwner compares the owner uid and gid, only available on unix, ignored elsewhere
+	WatchOwner
+
+	// DefaultWatchAttributes is the set of attributes compared by default
+	DefaultWatchAttributes = WatchModTime | WatchSize
+)
+
+// FileChangeStat is the change history of a watched file, see FileChangeRoutine.ChangeStats.
+type FileChangeStat struct {
+	// Changes is the number of changes detected, excluding the first run
+	Changes        int
+	LastChangeTime time.Time
+}
+
+// Stater returns the file info of a path, see FileChangeRoutine.Stater.
+type Stater interface {
+	Stat(path string) (os.FileInfo, error)
+}
+
+// StaterFunc is an adapter to use a function as a Stater, e.g. os.Stat.
+type StaterFunc func(path string) (os.FileInfo, error)
+
+// Stat implements the Stater interface
+func (sf StaterFunc) Stat(path string) (os.FileInfo, error) {
+	return sf(path)
+}
+
+// FSStater returns a Stater reading file info from fsys with fs.Stat, e.g. an fstest.MapFS in tests
+// or an alternative file system, to be set as FileChangeRoutine.Stater.
+// Watched paths are converted to fs.FS paths: slash-separated, relative to the root of fsys, so "/etc/app.conf"
+// and "etc/app.conf" are the same file.
+// Changes are only as precise as the file info of fsys, e.g. a file system without modification times
+// only detects size and mode changes, and an embed.FS never changes. Symlinks are not resolved separately.
+func FSStater(fsys fs.FS) Stater {
+	return StaterFunc(func(name string) (os.FileInfo, error) {
+		name = path.Clean(filepath.ToSlash(name))
+		name = strings.TrimPrefix(name, "/")
+		if name == "" {
+			name = "."
+		}
+		return fs.Stat(fsys, name)
+	})
+}
+
+// watchedFile is the state of a watched file.
+type watchedFile struct {
+	path string
+	stat os.FileInfo
+	// symlink target, when not following symlinks
+	target string
+	// handler if set, is called in place of the routine function
+	handler func(stat os.FileInfo, err error) error
+	// consecutive stat errors
+	statErrors int
+	changes    int
+	lastChange time.Time
+}
+
 // FileChangeRoutine implements an interval routine that calls a function on file change.
-// A file change is detected when the OS reported file ModTime or size has changed.
+// A file change is detected when the OS reported file ModTime or size has changed, see WatchAttributes.
 // Some important notes:
 // - the error interval is only triggered by an error returned by the function, not by file stat error
-// - the first run of Stats on file does not trigger the function (not considered a change)
-// - file stat error on a file only triggers a change once
+// - the first run of Stats on file does not trigger the function (not considered a change), unless FireOnStart is set
+// - file stat error on a file only triggers a change once, after StatErrorGrace consecutive errors
 type FileChangeRoutine struct {
 	OnFileChange func(file string, stat os.FileInfo, err error)
-	innerF       func() error
-	files        []string
-	fileStats    []os.FileInfo
-	once         *sync.Once
+	// OnChangesBatch is called with all the changes detected in a run, right before the function
+	OnChangesBatch func(changes []FileChange)
+	// WatchAttributes is the set of attributes compared to detect a change, DefaultWatchAttributes if unset
+	WatchAttributes FileAttributes
+	// FireOnStart if set to true, the first run triggers the function, e.g. to load the initial config
+	FireOnStart bool
+	// StatErrorGrace is the number of consecutive stat errors ignored before reporting a change,
+	// e.g. to ignore a file briefly missing during an atomic rename
+	StatErrorGrace int
+	// FollowSymlinks if set to true, the default, a symlink is followed and its final target is watched.
+	// If set to false, the symlink itself is watched, so that repointing it is a change even if the new
+	// target is older. This is the Kubernetes ConfigMap reload pattern: watch the mounted file with
+	// FollowSymlinks set to true to see content edits, or the "..data" symlink with false to see atomic swaps.
+	FollowSymlinks bool
+	// Stater if set, replaces os.Stat to get the file info, e.g. a fake in tests.
+	// It is used as is, regardless of FollowSymlinks.
+	Stater Stater
+	// StatConcurrency is the maximum number of files stat concurrently, e.g. for large sets on slow file systems.
+	// Files are stat one at a time by default.
+	StatConcurrency int
+	// StableFor if set, the function is only called once the changed files have been unchanged for that many
+	// consecutive runs, e.g. for large files written slowly. Intermediate changes are coalesced,
+	// OnChangesBatch receives the final state of each changed file. OnFileChange is still called on each change.
+	StableFor  int
+	pending    []FileChange
+	stableRuns int
+	innerF     func(ctx context.Context, changed []string) error
+	files      []*watchedFile
+	filesMu    sync.RWMutex
+	once       *sync.Once
+	changesMu  sync.Mutex
+	changes    chan FileChange
+	changesEnd bool
 
 	IntervalRoutine
 }
 
 // NewFileChangeRoutine creates a new FileChangeRoutine, which takes care of running f().
 // Parameters are equivalent to IntervalRoutine.
+// f may be nil if all files are added with AddFileWithHandler.
 func NewFileChangeRoutine(f func() error, runInterval time.Duration, retryInterval time.Duration) *FileChangeRoutine {
+	if f == nil {
+		return NewFileChangeRoutineCtx(nil, runInterval, retryInterval)
+	}
+	return NewFileChangeRoutineCtx(func(ctx context.Context) error {
+		return f()
+	}, runInterval, retryInterval)
+}
+
+// NewFileChangeRoutineCtx creates a new FileChangeRoutine like NewFileChangeRoutine,
+// f is given a context that is cancelled on Stop, see StartContext.
+func NewFileChangeRoutineCtx(f func(ctx context.Context) error, runInterval time.Duration, retryInterval time.Duration) *FileChangeRoutine {
+	var inner func(ctx context.Context, changed []string) error
+	if f != nil {
+		inner = func(ctx context.Context, changed []string) error {
+			return f(ctx)
+		}
+	}
+	return newFileChangeRoutine(inner, runInterval, retryInterval)
+}
+
+// NewFileChangeRoutineChanged creates a new FileChangeRoutine like NewFileChangeRoutine,
+// f is given the paths of the changed files, e.g. to reload selectively.
+// Files added with AddFileWithHandler are not included, their handler is called instead.
+// The list is empty on a first run with FireOnStart if no file exists.
+func NewFileChangeRoutineChanged(f func(changed []string) error, runInterval time.Duration, retryInterval time.Duration) *FileChangeRoutine {
+	return newFileChangeRoutine(func(ctx context.Context, changed []string) error {
+		return f(changed)
+	}, runInterval, retryInterval)
+}
+
+func newFileChangeRoutine(f func(ctx context.Context, changed []string) error, runInterval time.Duration, retryInterval time.Duration) *FileChangeRoutine {
 	fcr := &FileChangeRoutine{
-		IntervalRoutine: IntervalRoutine{
-			runInterval:   runInterval,
-			retryInterval: retryInterval,
-			force:         make(chan bool, 1),
-			done:          make(chan bool, 1),
-		},
-		innerF: f,
-		once:   &sync.Once{},
-	}
-	fcr.IntervalRoutine.runner = RunnerFunc(func() error {
-		return fcr.update()
-	})
+		innerF:         f,
+		once:           &sync.Once{},
+		FollowSymlinks: true,
+	}
+	fcr.IntervalRoutine.init(RunnerCtxFunc(func(ctx context.Context) error {
+		return fcr.update(ctx)
+	}), runInterval, retryInterval)
 	return fcr
 }
 
+// changesBufferSize is the capacity of the Changes channel.
+const changesBufferSize = 64
+
+// Changes returns a channel receiving the detected changes, created on the first call,
+// e.g. to process heavy reloads on a separate goroutine so that a slow reload does not delay detection.
+// Changes are sent in addition to calling the handlers and the routine function, which may be nil,
+// with the same timing, e.g. once stable with StableFor.
+// The channel is buffered and never blocks the routine: if the consumer is slow and the buffer is full,
+// the oldest change is dropped to make room for the latest. Restat the file to get its current state.
+// Sending never fails, so errors and retries only come from the handlers and the routine function,
+// a consumer failing to process a change must handle it itself.
+// It is closed when the routine exits.
+func (fcr *FileChangeRoutine) Changes() <-chan FileChange {
+	fcr.changesMu.Lock()
+	defer fcr.changesMu.Unlock()
+	if fcr.changes == nil {
+		fcr.changes = make(chan FileChange, changesBufferSize)
+		go func() {
+			<-fcr.IntervalRoutine.Done()
+			fcr.changesMu.Lock()
+			defer fcr.changesMu.Unlock()
+			fcr.changesEnd = true
+			close(fcr.changes)
+		}()
+	}
+	return fcr.changes
+}
+
+// publishChanges sends changes to the Changes channel if any.
+func (fcr *FileChangeRoutine) publishChanges(changes []FileChange) {
+	fcr.changesMu.Lock()
+	defer fcr.changesMu.Unlock()
+	if fcr.changes == nil || fcr.changesEnd {
+		return
+	}
+	for _, c := range changes {
+		select {
+		case fcr.changes <- c:
+			continue
+		default:
+		}
+		// full, drop the oldest
+		select {
+		case <-fcr.changes:
+		default:
+		}
+		fcr.changes <- c
+	}
+}
+
+// AddFileWithHandler adds a file to watch for updates, with its own handler called on change
+// in place of the routine function, e.g. to reload a certificate and a config separately.
+// Errors of all the handlers called in a run, and of the routine function, are combined with errors.Join
+// and trigger the retry interval. A single error is returned as is.
+// This function must be called prior to calling Start()
+func (fcr *FileChangeRoutine) AddFileWithHandler(file string, onChange func(stat os.FileInfo, err error) error) {
+	if file == "" {
+		return
+	}
+	fcr.files = append(fcr.files, &watchedFile{path: file, handler: onChange})
+}
+
 // AddFiles adds files to watch for updates.
 // Parameter is a list of file paths, empty path are ignored.
 // This function must be called prior to calling Start()
@@ -50,39 +250,282 @@ func (fcr *FileChangeRoutine) AddFiles(files ...string) {
 			// ignore empty files for convenience
 			continue
 		}
-		fcr.files = append(fcr.files, file)
-		fcr.fileStats = append(fcr.fileStats, nil)
+		fcr.files = append(fcr.files, &watchedFile{path: file})
 	}
 }
 
-func (fcr *FileChangeRoutine) update() error {
-	change := false
-	for i, file := range fcr.files {
-		stat, err := os.Stat(file)
-		ostat := fcr.fileStats[i]
+func (fcr *FileChangeRoutine) update(ctx context.Context) error {
+	var changes []FileChange
+	results, ok := fcr.statAllCtx(ctx)
+	if !ok {
+		// stopped, the outcome of this run does not matter
+		return nil
+	}
+	for i, wf := range fcr.files {
+		stat, target, err := results[i].stat, results[i].target, results[i].err
+		ostat := wf.stat
+		fcr.filesMu.Lock()
+		if err != nil {
+			wf.statErrors++
+		} else {
+			wf.statErrors = 0
+		}
+		statErrors := wf.statErrors
+		fcr.filesMu.Unlock()
 		if err != nil {
 			// error on stat, file probably does not exist or bad perm
 			if ostat == nil {
 				// no previous stat, dont trigger forever
 				continue
 			}
+			if statErrors <= fcr.StatErrorGrace {
+				// may be transient, keep the previous stat
+				continue
+			}
 		}
-		if ostat == nil || stat == nil || !stat.ModTime().Equal(ostat.ModTime()) || stat.Size() != ostat.Size() {
+		if ostat == nil || stat == nil || target != wf.target || fcr.changed(stat, ostat) {
 			if fcr.OnFileChange != nil {
-				fcr.OnFileChange(file, stat, err)
+				fcr.OnFileChange(wf.path, stat, err)
 			}
-			change = true
-			fcr.fileStats[i] = stat
+			changes = append(changes, FileChange{File: wf.path, Stat: stat, Err: err})
+			wf.stat = stat
+			wf.target = target
 		}
 	}
+	change := len(changes) > 0
+	first := false
 	fcr.once.Do(func() {
-		// dont trigger change on 1st run, it's not a change
-		change = false
+		// dont trigger change on 1st run, it's not a change, unless asked to load initial state
+		change = fcr.FireOnStart
+		first = true
 	})
+	if len(changes) > 0 && !first {
+		fcr.countChanges(changes)
+	}
+	if fcr.StableFor > 0 && !first {
+		if change {
+			// wait for the files to settle, only the final state matters
+			fcr.pending = mergeChanges(fcr.pending, changes)
+			fcr.stableRuns = 0
+			return nil
+		}
+		if len(fcr.pending) == 0 {
+			return nil
+		}
+		fcr.stableRuns++
+		if fcr.stableRuns < fcr.StableFor {
+			return nil
+		}
+		changes, fcr.pending = fcr.pending, nil
+		change = true
+	}
 
 	if !change {
 		// no error, no file change
 		return nil
 	}
-	return fcr.innerF()
+	if fcr.OnChangesBatch != nil {
+		fcr.OnChangesBatch(changes)
+	}
+	fcr.publishChanges(changes)
+	var errs []error
+	// the routine function is called for files without handler, or for FireOnStart without any file
+	callInner := len(changes) == 0
+	changed := []string{}
+	for _, c := range changes {
+		if h := fcr.handler(c.File); h != nil {
+			if err := h(c.Stat, c.Err); err != nil {
+				errs = append(errs, err)
+			}
+		} else {
+			callInner = true
+			changed = append(changed, c.File)
+		}
+	}
+	if callInner && fcr.innerF != nil {
+		if err := fcr.innerF(ctx, changed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// handler returns the handler of a file, nil if it has none.
+func (fcr *FileChangeRoutine) handler(file string) func(stat os.FileInfo, err error) error {
+	for _, wf := range fcr.files {
+		if wf.path == file {
+			return wf.handler
+		}
+	}
+	return nil
+}
+
+// MissingFiles returns the watched files whose stat currently fails, e.g. a misconfigured path.
+// A file is reported once its consecutive stat errors exceed StatErrorGrace.
+func (fcr *FileChangeRoutine) MissingFiles() []string {
+	fcr.filesMu.RLock()
+	defer fcr.filesMu.RUnlock()
+	var missing []string
+	for _, wf := range fcr.files {
+		if wf.statErrors > fcr.StatErrorGrace {
+			missing = append(missing, wf.path)
+		}
+	}
+	return missing
+}
+
+// statResult is the outcome of a file stat.
+type statResult struct {
+	stat   os.FileInfo
+	target string
+	err    error
+}
+
+// statAllCtx calls statAll, returning early if ctx is done, e.g. on Stop during a slow network file system stat.
+// ok is false if ctx is done. The stats run on a single goroutine per run, not one per file,
+// and on the calling goroutine if ctx can never be done.
+func (fcr *FileChangeRoutine) statAllCtx(ctx context.Context) (results []statResult, ok bool) {
+	if ctx.Done() == nil {
+		return fcr.statAll(ctx), true
+	}
+	// buffered so that abandoned stats do not leak
+	resc := make(chan []statResult, 1)
+	go func() {
+		resc <- fcr.statAll(ctx)
+	}()
+	select {
+	case results = <-resc:
+		return results, ctx.Err() == nil
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// statAll stats all the files, up to StatConcurrency at a time, results are in the order of the files.
+// Files not stat yet when ctx is done are skipped.
+func (fcr *FileChangeRoutine) statAll(ctx context.Context) []statResult {
+	results := make([]statResult, len(fcr.files))
+	if fcr.StatConcurrency <= 1 {
+		for i, wf := range fcr.files {
+			if ctx.Err() != nil {
+				break
+			}
+			stat, target, err := fcr.stat(wf.path)
+			results[i] = statResult{stat, target, err}
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, fcr.StatConcurrency)
+	var wg sync.WaitGroup
+	for i, wf := range fcr.files {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			stat, target, err := fcr.stat(path)
+			results[i] = statResult{stat, target, err}
+		}(i, wf.path)
+	}
+	wg.Wait()
+	return results
+}
+
+// mergeChanges adds changes to pending, keeping the latest change of each file.
+func mergeChanges(pending []FileChange, changes []FileChange) []FileChange {
+	for _, c := range changes {
+		found := false
+		for i := range pending {
+			if pending[i].File == c.File {
+				pending[i] = c
+				found = true
+			}
+		}
+		if !found {
+			pending = append(pending, c)
+		}
+	}
+	return pending
+}
+
+// countChanges updates the stats of changed files.
+func (fcr *FileChangeRoutine) countChanges(changes []FileChange) {
+	now := time.Now()
+	fcr.filesMu.Lock()
+	defer fcr.filesMu.Unlock()
+	for _, c := range changes {
+		for _, wf := range fcr.files {
+			if wf.path == c.File {
+				wf.changes++
+				wf.lastChange = now
+			}
+		}
+	}
+}
+
+// ChangeStats returns the change history of each watched file, keyed by path,
+// e.g. to detect a file changing far more often than expected.
+func (fcr *FileChangeRoutine) ChangeStats() map[string]FileChangeStat {
+	fcr.filesMu.RLock()
+	defer fcr.filesMu.RUnlock()
+	stats := make(map[string]FileChangeStat, len(fcr.files))
+	for _, wf := range fcr.files {
+		stats[wf.path] = FileChangeStat{Changes: wf.changes, LastChangeTime: wf.lastChange}
+	}
+	return stats
+}
+
+// stat returns the file info, and the symlink target if the file is a symlink not followed.
+func (fcr *FileChangeRoutine) stat(path string) (os.FileInfo, string, error) {
+	if fcr.Stater != nil {
+		stat, err := fcr.Stater.Stat(path)
+		return stat, "", err
+	}
+	if fcr.FollowSymlinks {
+		stat, err := os.Stat(path)
+		return stat, "", err
+	}
+	stat, err := os.Lstat(path)
+	if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		return stat, "", err
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return stat, target, nil
+}
+
+// changed compares the watched attributes of 2 stats.
+func (fcr *FileChangeRoutine) changed(stat os.FileInfo, ostat os.FileInfo) bool {
+	attrs := fcr.WatchAttributes
+	if attrs == 0 {
+		attrs = DefaultWatchAttributes
+	}
+	if attrs&WatchModTime != 0 && !stat.ModTime().Equal(ostat.ModTime()) {
+		return true
+	}
+	if attrs&WatchSize != 0 && stat.Size() != ostat.Size() {
+		return true
+	}
+	if attrs&WatchMode != 0 && stat.Mode() != ostat.Mode() {
+		return true
+	}
+	if attrs&WatchOwner != 0 {
+		uid, gid, ok := fileOwner(stat)
+		ouid, ogid, ook := fileOwner(ostat)
+		if ok && ook && (uid != ouid || gid != ogid) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,568 @@
+package goodroutine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present")
+	if err := os.WriteFile(present, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	fcr := NewFileChangeRoutine(func() error {
+		return nil
+	}, time.Hour, 0)
+	fcr.StatErrorGrace = 1
+	fcr.AddFiles(present, missing)
+
+	fcr.update(context.Background())
+	if g := fcr.MissingFiles(); len(g) != 0 {
+		t.Errorf("No file should be reported within grace, got=%v", g)
+	}
+	fcr.update(context.Background())
+	if g := fcr.MissingFiles(); len(g) != 1 || g[0] != missing {
+		t.Errorf("Incorrect missing files, got=%v, want=[%v]", g, missing)
+	}
+
+	if err := os.WriteFile(missing, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fcr.update(context.Background())
+	if g := fcr.MissingFiles(); len(g) != 0 {
+		t.Errorf("No file should be missing, got=%v", g)
+	}
+}
+
+func TestFileChangeRoutineStartContext(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxs := make(chan context.Context, 1)
+	fcr := NewFileChangeRoutineCtx(func(ctx context.Context) error {
+		ctxs <- ctx
+		return nil
+	}, time.Hour, 0)
+	fcr.FireOnStart = true
+	fcr.AddFiles(file)
+	ctx, cancel := context.WithCancel(context.Background())
+	fcr.StartContext(ctx)
+
+	var fctx context.Context
+	select {
+	case fctx = <-ctxs:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Fatal("function was not called")
+	}
+	cancel()
+	select {
+	case <-fcr.Done():
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("routine did not exit")
+	}
+	if fctx.Err() == nil {
+		t.Error("function context should be cancelled")
+	}
+}
+
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    os.FileMode
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return false }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+func (fi fakeFileInfo) Mode() os.FileMode {
+	if fi.mode == 0 {
+		return 0644
+	}
+	return fi.mode
+}
+
+func TestFileChangeStater(t *testing.T) {
+	files := map[string]os.FileInfo{}
+	stater := StaterFunc(func(path string) (os.FileInfo, error) {
+		if fi, ok := files[path]; ok {
+			return fi, nil
+		}
+		return nil, os.ErrNotExist
+	})
+	calls := 0
+	fcr := NewFileChangeRoutine(func() error {
+		calls++
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = stater
+	fcr.AddFiles("config")
+
+	now := time.Now()
+	files["config"] = fakeFileInfo{name: "config", size: 1, modTime: now}
+	fcr.update(context.Background())
+	if g, w := calls, 0; g != w {
+		t.Errorf("First run should not be a change, got=%v, want=%v", g, w)
+	}
+	fcr.update(context.Background())
+	if g, w := calls, 0; g != w {
+		t.Errorf("Unchanged file should not be a change, got=%v, want=%v", g, w)
+	}
+	files["config"] = fakeFileInfo{name: "config", size: 2, modTime: now}
+	fcr.update(context.Background())
+	if g, w := calls, 1; g != w {
+		t.Errorf("Size change should be a change, got=%v, want=%v", g, w)
+	}
+	files["config"] = fakeFileInfo{name: "config", size: 2, modTime: now.Add(time.Second)}
+	fcr.update(context.Background())
+	if g, w := calls, 2; g != w {
+		t.Errorf("ModTime change should be a change, got=%v, want=%v", g, w)
+	}
+	delete(files, "config")
+	fcr.update(context.Background())
+	if g, w := calls, 3; g != w {
+		t.Errorf("Missing file should be a change, got=%v, want=%v", g, w)
+	}
+	stat := fcr.ChangeStats()["config"]
+	if g, w := stat.Changes, 3; g != w {
+		t.Errorf("Incorrect change count, got=%v, want=%v", g, w)
+	}
+	if stat.LastChangeTime.IsZero() {
+		t.Error("Last change time should be set")
+	}
+}
+
+func TestStatConcurrency(t *testing.T) {
+	now := time.Now()
+	files := map[string]os.FileInfo{}
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("file%02d", i)
+		paths = append(paths, path)
+		files[path] = fakeFileInfo{name: path, size: 1, modTime: now}
+	}
+	fcr := NewFileChangeRoutine(func() error {
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		return files[path], nil
+	})
+	fcr.StatConcurrency = 4
+	fcr.AddFiles(paths...)
+	var batch []FileChange
+	fcr.OnChangesBatch = func(changes []FileChange) {
+		batch = changes
+	}
+
+	fcr.update(context.Background())
+	files["file03"] = fakeFileInfo{name: "file03", size: 2, modTime: now}
+	files["file17"] = fakeFileInfo{name: "file17", size: 2, modTime: now}
+	fcr.update(context.Background())
+	if len(batch) != 2 || batch[0].File != "file03" || batch[1].File != "file17" {
+		t.Errorf("Incorrect changes, got=%v", batch)
+	}
+}
+
+func TestStableFor(t *testing.T) {
+	now := time.Now()
+	info := fakeFileInfo{name: "big", size: 1, modTime: now}
+	fcr := NewFileChangeRoutine(func() error {
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		return info, nil
+	})
+	fcr.StableFor = 2
+	fcr.AddFiles("big")
+	var batches [][]FileChange
+	fcr.OnChangesBatch = func(changes []FileChange) {
+		batches = append(batches, changes)
+	}
+
+	fcr.update(context.Background())
+	// keeps growing
+	for size := int64(2); size <= 4; size++ {
+		info.size = size
+		fcr.update(context.Background())
+	}
+	if len(batches) != 0 {
+		t.Errorf("No batch expected while growing, got=%v", batches)
+	}
+	// stops, stable for 2 runs
+	fcr.update(context.Background())
+	if len(batches) != 0 {
+		t.Errorf("No batch expected before stable, got=%v", batches)
+	}
+	fcr.update(context.Background())
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("A single batch expected once stable, got=%v", batches)
+	}
+	if g, w := batches[0][0].Stat.Size(), int64(4); g != w {
+		t.Errorf("Batch should have the final state, got=%v, want=%v", g, w)
+	}
+	fcr.update(context.Background())
+	if len(batches) != 1 {
+		t.Errorf("No batch expected after delivery, got=%v", batches)
+	}
+}
+
+func TestAddFileWithHandler(t *testing.T) {
+	now := time.Now()
+	files := map[string]os.FileInfo{
+		"cert":   fakeFileInfo{name: "cert", size: 1, modTime: now},
+		"config": fakeFileInfo{name: "config", size: 1, modTime: now},
+	}
+	certErr := errors.New("bad cert")
+	var handled []string
+	innerCalls := 0
+	fcr := NewFileChangeRoutine(func() error {
+		innerCalls++
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		return files[path], nil
+	})
+	fcr.AddFileWithHandler("cert", func(stat os.FileInfo, err error) error {
+		handled = append(handled, "cert")
+		return certErr
+	})
+	fcr.AddFiles("config")
+
+	fcr.update(context.Background())
+	files["cert"] = fakeFileInfo{name: "cert", size: 2, modTime: now}
+	if g, w := fcr.update(context.Background()), certErr; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+	if len(handled) != 1 || innerCalls != 0 {
+		t.Errorf("Only the cert handler should be called, got=%v, %v", handled, innerCalls)
+	}
+
+	files["config"] = fakeFileInfo{name: "config", size: 2, modTime: now}
+	if err := fcr.update(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(handled) != 1 || innerCalls != 1 {
+		t.Errorf("Only the routine function should be called, got=%v, %v", handled, innerCalls)
+	}
+}
+
+func TestFileChangeRoutineChanged(t *testing.T) {
+	now := time.Now()
+	files := map[string]os.FileInfo{
+		"a":    fakeFileInfo{name: "a", size: 1, modTime: now},
+		"b":    fakeFileInfo{name: "b", size: 1, modTime: now},
+		"cert": fakeFileInfo{name: "cert", size: 1, modTime: now},
+	}
+	var got []string
+	fcr := NewFileChangeRoutineChanged(func(changed []string) error {
+		got = changed
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		return files[path], nil
+	})
+	fcr.AddFiles("a", "b")
+	fcr.AddFileWithHandler("cert", func(stat os.FileInfo, err error) error {
+		return nil
+	})
+
+	fcr.update(context.Background())
+	files["b"] = fakeFileInfo{name: "b", size: 2, modTime: now}
+	files["cert"] = fakeFileInfo{name: "cert", size: 2, modTime: now}
+	if err := fcr.update(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if g, w := fmt.Sprint(got), "[b]"; g != w {
+		t.Errorf("Incorrect changed files, got=%v, want=%v", g, w)
+	}
+}
+
+func TestFileChangeRoutineChanges(t *testing.T) {
+	now := time.Now()
+	files := map[string]os.FileInfo{
+		"a": fakeFileInfo{name: "a", size: 1, modTime: now},
+		"b": fakeFileInfo{name: "b", size: 1, modTime: now},
+	}
+	fcr := NewFileChangeRoutine(nil, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		return files[path], nil
+	})
+	fcr.AddFiles("a", "b")
+	changes := fcr.Changes()
+
+	fcr.update(context.Background())
+	files["b"] = fakeFileInfo{name: "b", size: 2, modTime: now}
+	if err := fcr.update(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	select {
+	case c := <-changes:
+		if g, w := c.File, "b"; g != w {
+			t.Errorf("Incorrect change, got=%v, want=%v", g, w)
+		}
+	default:
+		t.Error("Change was not sent")
+	}
+
+	fcr.Stop()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("Unexpected change")
+		}
+	case <-time.After(time.Second):
+		t.Error("Changes was not closed")
+	}
+}
+
+func TestFSStater(t *testing.T) {
+	now := time.Now()
+	fsys := fstest.MapFS{
+		"etc/app.conf": &fstest.MapFile{Data: []byte("a"), ModTime: now},
+	}
+	calls := 0
+	fcr := NewFileChangeRoutine(func() error {
+		calls++
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = FSStater(fsys)
+	fcr.AddFiles("/etc/app.conf")
+
+	fcr.update(context.Background())
+	if g, w := len(fcr.MissingFiles()), 0; g != w {
+		t.Errorf("Incorrect missing files, got=%v, want=%v", g, w)
+	}
+	fsys["etc/app.conf"] = &fstest.MapFile{Data: []byte("ab"), ModTime: now}
+	if err := fcr.update(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if g, w := calls, 1; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+}
+
+func TestStatErrorGrace(t *testing.T) {
+	now := time.Now()
+	info := fakeFileInfo{name: "config", size: 1, modTime: now}
+	var statErr error
+	var changes []FileChange
+	fcr := NewFileChangeRoutine(nil, time.Hour, 0)
+	fcr.StatErrorGrace = 2
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		if statErr != nil {
+			return nil, statErr
+		}
+		return info, nil
+	})
+	fcr.OnChangesBatch = func(c []FileChange) {
+		changes = append(changes, c...)
+	}
+	fcr.AddFiles("config")
+	fcr.update(context.Background())
+
+	// a blip within grace that recovers is not a change
+	statErr = os.ErrNotExist
+	fcr.update(context.Background())
+	fcr.update(context.Background())
+	statErr = nil
+	fcr.update(context.Background())
+	if g, w := len(changes), 0; g != w {
+		t.Errorf("Incorrect changes after a blip, got=%v, want=%v", g, w)
+	}
+
+	// an error past grace is a single change
+	statErr = os.ErrNotExist
+	for i := 0; i < 5; i++ {
+		fcr.update(context.Background())
+	}
+	if g, w := len(changes), 1; g != w {
+		t.Fatalf("Incorrect changes after a persistent error, got=%v, want=%v", g, w)
+	}
+	if changes[0].Stat != nil || changes[0].Err != os.ErrNotExist {
+		t.Errorf("Incorrect change, got=%+v", changes[0])
+	}
+}
+
+func TestFireOnStart(t *testing.T) {
+	now := time.Now()
+	stater := StaterFunc(func(path string) (os.FileInfo, error) {
+		return fakeFileInfo{name: path, size: 1, modTime: now}, nil
+	})
+	for _, fire := range []bool{false, true} {
+		calls := 0
+		fcr := NewFileChangeRoutine(func() error {
+			calls++
+			return nil
+		}, time.Hour, 0)
+		fcr.FireOnStart = fire
+		fcr.Stater = stater
+		fcr.AddFiles("config")
+
+		fcr.update(context.Background())
+		fcr.update(context.Background())
+		want := 0
+		if fire {
+			want = 1
+		}
+		if g, w := calls, want; g != w {
+			t.Errorf("Incorrect calls with FireOnStart=%v, got=%v, want=%v", fire, g, w)
+		}
+	}
+}
+
+func TestOnChangesBatch(t *testing.T) {
+	now := time.Now()
+	files := map[string]os.FileInfo{
+		"a": fakeFileInfo{name: "a", size: 1, modTime: now},
+		"b": fakeFileInfo{name: "b", size: 1, modTime: now},
+		"c": fakeFileInfo{name: "c", size: 1, modTime: now},
+	}
+	fcr := NewFileChangeRoutine(func() error {
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		if fi, ok := files[path]; ok {
+			return fi, nil
+		}
+		return nil, os.ErrNotExist
+	})
+	var batches [][]FileChange
+	fcr.OnChangesBatch = func(changes []FileChange) {
+		batches = append(batches, changes)
+	}
+	fcr.AddFiles("a", "b", "c")
+	fcr.update(context.Background())
+
+	changed := fakeFileInfo{name: "a", size: 2, modTime: now}
+	files["a"] = changed
+	delete(files, "b")
+	fcr.update(context.Background())
+	if g, w := len(batches), 1; g != w {
+		t.Fatalf("Incorrect batches, got=%v, want=%v", g, w)
+	}
+	batch := batches[0]
+	if g, w := len(batch), 2; g != w {
+		t.Fatalf("Incorrect changes, got=%v, want=%v", batch, w)
+	}
+	if batch[0].File != "a" || batch[0].Stat != changed || batch[0].Err != nil {
+		t.Errorf("Incorrect change, got=%+v", batch[0])
+	}
+	if batch[1].File != "b" || batch[1].Stat != nil || batch[1].Err != os.ErrNotExist {
+		t.Errorf("Incorrect change, got=%+v", batch[1])
+	}
+}
+
+func TestWatchMode(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		attrs FileAttributes
+		calls int
+	}{
+		{0, 0},
+		{DefaultWatchAttributes | WatchMode, 1},
+	} {
+		info := fakeFileInfo{name: "key", size: 1, modTime: now}
+		calls := 0
+		fcr := NewFileChangeRoutine(func() error {
+			calls++
+			return nil
+		}, time.Hour, 0)
+		fcr.WatchAttributes = c.attrs
+		fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+			return info, nil
+		})
+		fcr.AddFiles("key")
+		fcr.update(context.Background())
+
+		// chmod only
+		info.mode = 0600
+		fcr.update(context.Background())
+		if g, w := calls, c.calls; g != w {
+			t.Errorf("Incorrect calls with attributes %v, got=%v, want=%v", c.attrs, g, w)
+		}
+	}
+}
+
+func TestFollowSymlinksDisabled(t *testing.T) {
+	dir := t.TempDir()
+	v1 := filepath.Join(dir, "v1")
+	v2 := filepath.Join(dir, "v2")
+	for _, p := range []string{v1, v2} {
+		if err := os.WriteFile(p, []byte("config"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the new target is older than the current one
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(v2, old, old)
+	data := filepath.Join(dir, "..data")
+	if err := os.Symlink(v1, data); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	fcr := NewFileChangeRoutine(func() error {
+		calls++
+		return nil
+	}, time.Hour, 0)
+	fcr.FollowSymlinks = false
+	fcr.AddFiles(data)
+	fcr.update(context.Background())
+
+	// atomic swap, like a ConfigMap update
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(v2, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcr.update(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if g, w := calls, 1; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+}
+
+func TestFileChangeRoutineStopDuringStat(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+	fcr := NewFileChangeRoutine(func() error {
+		return nil
+	}, time.Hour, 0)
+	fcr.Stater = StaterFunc(func(path string) (os.FileInfo, error) {
+		// hung network file system
+		<-release
+		return nil, os.ErrNotExist
+	})
+	fcr.AddFiles("config")
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fcr.update(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Stopping should not fail the run, got=%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("update did not return on stop")
+	}
+}
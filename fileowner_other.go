@@ -0,0 +1,11 @@
+//go:build !unix
+// +build !unix
+
+package goodroutine
+
+import "os"
+
+// fileOwner returns the uid and gid of a file, ownership is not available on this platform.
+func fileOwner(stat os.FileInfo) (uid int, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+// +build unix
+
+package goodroutine
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of a file, ok is false if not available.
+func fileOwner(stat os.FileInfo) (uid int, gid int, ok bool) {
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,191 @@
+package goodroutine
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrDependencyCycle is returned when the dependencies of a RoutineGroup form a cycle.
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// ErrUnknownDependency is returned when a dependency was not added to the RoutineGroup.
+var ErrUnknownDependency = errors.New("unknown dependency")
+
+// RoutineGroup manages the lifecycle of several routines.
+// Routines are started in dependency order, so that initialization is predictable.
+// Any Routine of the package can be added as is, e.g. a ConcurrentRoutine or a HealthCheckRoutine,
+// so that its own Start and StopAndWait are used, and a HealthChecker through HealthChecker.AsRoutine.
+type RoutineGroup struct {
+	mu       sync.Mutex
+	routines []Routine
+	deps     map[Routine][]Routine
+	started  []Routine
+
+	// WaitForDeps if set to true, StartAll waits for the first successful run of the dependencies of a routine before starting it
+	WaitForDeps bool
+}
+
+// NewRoutineGroup creates a new empty RoutineGroup.
+func NewRoutineGroup() *RoutineGroup {
+	return &RoutineGroup{
+		deps: make(map[Routine][]Routine),
+	}
+}
+
+// Add adds a routine without dependencies.
+// This function must be called prior to calling StartAll()
+func (rg *RoutineGroup) Add(r Routine) {
+	rg.AddWithDeps(r)
+}
+
+// AddWithDeps adds a routine that must start after its dependencies.
+// Dependencies must also be added to the group.
+// This function must be called prior to calling StartAll()
+func (rg *RoutineGroup) AddWithDeps(r Routine, deps ...Routine) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if _, ok := rg.deps[r]; !ok {
+		rg.routines = append(rg.routines, r)
+	}
+	rg.deps[r] = append(rg.deps[r], deps...)
+}
+
+// StartAll starts all routines, dependencies first, otherwise in the order they were added.
+// If WaitForDeps is set, it blocks until dependencies had a successful run, or ctx is done.
+// Only the routines of the package can report it, other dependencies, e.g. test fakes, are not waited for.
+// An error is returned without starting any routine if dependencies form a cycle or are unknown.
+func (rg *RoutineGroup) StartAll(ctx context.Context) error {
+	rg.mu.Lock()
+	order, err := rg.sort()
+	rg.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range order {
+		if rg.WaitForDeps {
+			for _, dep := range rg.deps[r] {
+				ds, ok := dep.(interface{ firstSuccess() <-chan struct{} })
+				if !ok {
+					continue
+				}
+				select {
+				case <-ds.firstSuccess():
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		r.Start()
+		rg.mu.Lock()
+		rg.started = append(rg.started, r)
+		rg.mu.Unlock()
+	}
+	return nil
+}
+
+// StopAll stops all routines.
+func (rg *RoutineGroup) StopAll() {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	for _, r := range rg.routines {
+		r.Stop()
+	}
+}
+
+// StopAllReverseAndWait stops the routines one at a time in the reverse order they were started by StartAll,
+// waiting for each to exit, including its RunOnStop final run, before stopping the next one.
+// Routines are waited for with their StopAndWait if they have one, e.g. a ConcurrentRoutine waits for its workers,
+// otherwise with Stop and Done.
+// Dependents are thus stopped before their dependencies, and a routine never observes a dependency stopping.
+// Routines added but not started are stopped last.
+// Errors of the final runs are returned joined with errors.Join. If ctx is done, the remaining routines
+// are stopped without waiting, and ctx.Err() is part of the returned error.
+func (rg *RoutineGroup) StopAllReverseAndWait(ctx context.Context) error {
+	rg.mu.Lock()
+	order := make([]Routine, 0, len(rg.routines))
+	started := make(map[Routine]bool, len(rg.started))
+	for i := len(rg.started) - 1; i >= 0; i-- {
+		order = append(order, rg.started[i])
+		started[rg.started[i]] = true
+	}
+	for _, r := range rg.routines {
+		if !started[r] {
+			order = append(order, r)
+		}
+	}
+	rg.mu.Unlock()
+
+	var errs []error
+	for i, r := range order {
+		if err := stopAndWait(ctx, r); err != nil {
+			for _, rest := range order[i+1:] {
+				rest.Stop()
+			}
+			errs = append(errs, err)
+			break
+		}
+		if fe, ok := r.(interface{ finalError() error }); ok {
+			if err := fe.finalError(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stopAndWait stops r and waits for it to exit, or ctx to be done.
+func stopAndWait(ctx context.Context, r Routine) error {
+	if sw, ok := r.(interface {
+		StopAndWait(ctx context.Context) error
+	}); ok {
+		return sw.StopAndWait(ctx)
+	}
+	r.Stop()
+	select {
+	case <-r.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sort returns the routines in topological order, must be called with lock held.
+func (rg *RoutineGroup) sort() ([]Routine, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[Routine]int)
+	order := make([]Routine, 0, len(rg.routines))
+	var visit func(r Routine) error
+	visit = func(r Routine) error {
+		switch marks[r] {
+		case visiting:
+			return ErrDependencyCycle
+		case visited:
+			return nil
+		}
+		marks[r] = visiting
+		for _, dep := range rg.deps[r] {
+			if _, ok := rg.deps[dep]; !ok {
+				return ErrUnknownDependency
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		marks[r] = visited
+		order = append(order, r)
+		return nil
+	}
+
+	for _, r := range rg.routines {
+		if err := visit(r); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
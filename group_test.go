@@ -0,0 +1,189 @@
+package goodroutine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoutineGroupOrder(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string, fails int) Runner {
+		return RunnerFunc(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name)
+			if fails > 0 {
+				fails--
+				return errors.New("error")
+			}
+			return nil
+		})
+	}
+
+	warmer := NewIntervalRoutine(record("warmer", 2), time.Hour, time.Millisecond)
+	health := NewIntervalRoutine(record("health", 0), time.Hour, 0)
+	other := NewIntervalRoutine(record("other", 0), time.Hour, 0)
+	rg := NewRoutineGroup()
+	rg.WaitForDeps = true
+	rg.AddWithDeps(health, warmer)
+	rg.Add(warmer)
+	rg.Add(other)
+	defer rg.StopAll()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rg.StartAll(ctx); err != nil {
+		t.Fatalf("Unexpected error, got=%v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// health only starts after the warmer succeeded
+	warmers := 0
+	for _, c := range calls {
+		switch c {
+		case "warmer":
+			warmers++
+		case "health":
+			if g, w := warmers, 3; g != w {
+				t.Errorf("Dependent started too early, calls=%v", calls)
+			}
+		}
+	}
+	if g, w := len(calls), 5; g != w {
+		t.Errorf("Incorrect calls, got=%v", calls)
+	}
+}
+
+func TestRoutineGroupCycle(t *testing.T) {
+	f := RunnerFunc(func() error {
+		t.Error("function should not be called")
+		return nil
+	})
+	a := NewIntervalRoutine(f, 0, 0)
+	b := NewIntervalRoutine(f, 0, 0)
+	c := NewIntervalRoutine(f, 0, 0)
+	rg := NewRoutineGroup()
+	rg.Add(c)
+	rg.AddWithDeps(a, b)
+	rg.AddWithDeps(b, a)
+	if g, w := rg.StartAll(context.Background()), ErrDependencyCycle; g != w {
+		t.Errorf("Error does not match, got=%v, want=%v", g, w)
+	}
+
+	rg = NewRoutineGroup()
+	rg.AddWithDeps(a, b)
+	if g, w := rg.StartAll(context.Background()), ErrUnknownDependency; g != w {
+		t.Errorf("Error does not match, got=%v, want=%v", g, w)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestStopAllReverseAndWait(t *testing.T) {
+	var mu sync.Mutex
+	var stopping bool
+	var calls []string
+	flushErr := errors.New("flush failed")
+	record := func(name string, err error) Runner {
+		return RunnerFunc(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !stopping {
+				return nil
+			}
+			calls = append(calls, name)
+			return err
+		})
+	}
+	db := NewIntervalRoutine(record("db", nil), time.Hour, 0)
+	cache := NewIntervalRoutine(record("cache", flushErr), time.Hour, 0)
+	api := NewIntervalRoutine(record("api", nil), time.Hour, 0)
+	for _, r := range []*IntervalRoutine{db, cache, api} {
+		r.RunOnStop = true
+	}
+	rg := NewRoutineGroup()
+	rg.AddWithDeps(api, cache)
+	rg.AddWithDeps(cache, db)
+	rg.Add(db)
+	if err := rg.StartAll(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, r := range []*IntervalRoutine{db, cache, api} {
+		r.WaitForFirstRun(context.Background())
+	}
+
+	mu.Lock()
+	stopping = true
+	mu.Unlock()
+	err := rg.StopAllReverseAndWait(context.Background())
+	if !errors.Is(err, flushErr) {
+		t.Errorf("Incorrect error, got=%v, want=%v", err, flushErr)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if g, w := len(calls), 3; g != w {
+		t.Fatalf("Incorrect final runs, got=%v, want=%v", calls, w)
+	}
+	for i, w := range []string{"api", "cache", "db"} {
+		if g := calls[i]; g != w {
+			t.Errorf("Incorrect stop order, got=%v, want=%v", calls, w)
+		}
+	}
+}
+
+func TestRoutineGroupRoutines(t *testing.T) {
+	var finished int32
+	started := make(chan bool, 1)
+	cr := NewConcurrentRoutine(func() error {
+		started <- true
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		return nil
+	}, time.Hour, 0, 2)
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return nil
+	}), false, 1, 1)
+	checker := hc.AsRoutine(time.Hour, 0)
+	hcr := NewHealthCheckRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0, false, 1, 1)
+	hcr.ProbeJitter = 0.1
+
+	rg := NewRoutineGroup()
+	rg.WaitForDeps = true
+	rg.AddWithDeps(cr, checker)
+	rg.Add(checker)
+	rg.Add(hcr)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rg.StartAll(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	<-started
+	if !hc.IsUp() {
+		t.Error("checker should be up before its dependent starts")
+	}
+	if g, w := hcr.IntervalRoutine.Jitter, 0.1; g != w {
+		t.Errorf("Incorrect jitter, got=%v, want=%v", g, w)
+	}
+
+	if err := rg.StopAllReverseAndWait(ctx); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("StopAllReverseAndWait should wait for workers")
+	}
+	for _, r := range []Routine{cr, checker, hcr} {
+		select {
+		case <-r.Done():
+		default:
+			t.Errorf("Routine should have exited")
+		}
+	}
+}
@@ -1,35 +1,128 @@
 package goodroutine
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// ErrLowScore is the error recorded when a ScoringRunner returns a score under MinScore.
+var ErrLowScore = errors.New("health score too low")
+
+// ScoringRunner implements a health check returning a score, from 0.0 (unhealthy) to 1.0 (healthy).
+// It is used by HealthChecker in place of Runner when available.
+type ScoringRunner interface {
+	IntervalScore() (float64, error)
+}
+
+// ErrUnhealthy is the error recorded when a BoolRunnerFunc or BoolRunnerCtxFunc returns false.
+var ErrUnhealthy = errors.New("unhealthy")
+
+// The BoolRunnerFunc type is an adapter to allow the use of
+// ordinary functions returning a health boolean as Runner,
+// false is reported as ErrUnhealthy.
+type BoolRunnerFunc func() bool
+
+// IntervalRun implements the Runner interface
+func (rf BoolRunnerFunc) IntervalRun() error {
+	if !rf() {
+		return ErrUnhealthy
+	}
+	return nil
+}
+
+// The BoolRunnerCtxFunc type is an adapter to allow the use of
+// ordinary functions taking a context and returning a health boolean as Runner and RunnerCtx,
+// false is reported as ErrUnhealthy.
+type BoolRunnerCtxFunc func(ctx context.Context) bool
+
+// IntervalRun implements the Runner interface, using a background context
+func (rf BoolRunnerCtxFunc) IntervalRun() error {
+	return rf.IntervalRunCtx(context.Background())
+}
+
+// IntervalRunCtx implements the RunnerCtx interface
+func (rf BoolRunnerCtxFunc) IntervalRunCtx(ctx context.Context) error {
+	if !rf(ctx) {
+		return ErrUnhealthy
+	}
+	return nil
+}
+
+// DetailedRunner implements a health check returning details along with the error, e.g. latency or upstream version.
+// It is used by HealthChecker in place of Runner when available, see Details.
+type DetailedRunner interface {
+	CheckDetailed() (map[string]interface{}, error)
+}
+
 // HealthChecker implements a health check, using a threshold for up / down logic.
-// It can be combined with an IntervalRoutine to implement a health check goroutine.
+// It can be combined with an IntervalRoutine to implement a health check goroutine, or run standalone with Start.
 type HealthChecker struct {
 	mu            sync.RWMutex
+	runMu         sync.Mutex
+	name          string
 	runner        Runner
 	state         int32
+	forced        int32
 	ups           int
 	downs         int
 	thresholdUp   int
 	thresholdDown int
 	lastErr       error
 	firstRun      bool
+	lastChange    time.Time
+	fastChange    bool
+	downSince     time.Time
+	downtime      time.Duration
+	score         float64
+	details       map[string]interface{}
+	listeners     []*listener
+	routine       *IntervalRoutine
 
 	// OnUp is called when state changes to up, numDowns is number of prior downs
 	OnUp func(numUps int, numDowns int)
 	// OnDown is called when state changes to down, numUps is number of prior ups, lastErr is last error recorded
 	OnDown func(numUps int, numDowns int, lastErr error)
-	// NoRecover if set to true, panics are not recovered
+	// OnError is called on every failed run, even without a state change, numDowns includes this run
+	OnError func(err error, numDowns int)
+	// OnEvent is called for every run and transition, after the specific callbacks
+	OnEvent func(ev Event)
+	// NoRecover if set to true, a panic of the check is not recorded, otherwise it counts as a failed check,
+	// see PanicError. Either way the panic is then propagated, to be recovered by the routine running the check.
 	NoRecover bool
-	// FastStart if set to true, threshold fully apply from start
+	// FastStart if set to true, the first run sets the state in either direction, thresholds apply afterwards.
+	// It is a shorthand for both FastStartUp and FastStartDown, and must be set to false to use them separately.
 	FastStart bool
+	// FastStartUp if set to true, a first successful run sets the state up
+	FastStartUp bool
+	// FastStartDown if set to true, a first failed run sets the state down
+	FastStartDown bool
+	// IsFailure if set, decides whether an error counts as a failure, other errors count as successes.
+	// By default any error is a failure.
+	IsFailure func(err error) bool
+	// MinScore is the score under which a run of a ScoringRunner counts as an error, 0.5 if unset
+	MinScore float64
+	// CheckTimeout if set, bounds the duration of a check, a check running longer counts as an error.
+	// A RunnerCtx sees its context cancelled, a plain Runner is left to finish in the background.
+	CheckTimeout time.Duration
+	// ProbeJitter if set, randomizes the probe interval by up to that fraction in either direction,
+	// so that a fleet does not probe shared dependencies in sync, see IntervalRoutine.Jitter.
+	// It applies to the routine created by Start, and to a HealthCheckRoutine.
+	ProbeJitter float64
+	// MinStateDuration if set, a transition also requires the current state to have lasted that long.
+	// When the threshold is met earlier, the transition is held until a run after that duration,
+	// provided the consecutive successes or failures are still ongoing. It does not apply to FastStart.
+	MinStateDuration time.Duration
 }
 
 // NewHealthChecker creates a new HealthChecker.
-// f is the function to run to obtain the health.
+// runner is the function to run to obtain the health, if it implements RunnerCtx it is given a context.
+// runner may be nil if the health is only pushed with Report.
 // defaultState is the default up / down state before any run occurs.
 // thresholdUp defines the number of non-error runs before going from down to up.
 // thresholdDown defines the number of error runs before going from up to down.
@@ -53,76 +146,800 @@ func NewHealthChecker(runner Runner, defaultState bool, thresholdUp int, thresho
 	return hrt
 }
 
+// NewHealthCheckerFromRoutine creates a new HealthChecker fed by the runs of rt, instead of running its own check,
+// so that the same work serves both maintenance and health without extra load on dependencies.
+// A run returning ErrRunAgain counts as successful, like in Results.
+// The checker consumes rt.Results, which must not be read elsewhere, and should be created before rt is started
+// so that no run is missed. Thresholds are equivalent to NewHealthChecker, the default state is down.
+func NewHealthCheckerFromRoutine(rt *IntervalRoutine, thresholdUp int, thresholdDown int) *HealthChecker {
+	hrt := NewHealthChecker(nil, false, thresholdUp, thresholdDown)
+	results := rt.Results()
+	go func() {
+		for res := range results {
+			hrt.Report(res.Err)
+		}
+	}()
+	return hrt
+}
+
 // Reset sets the healthcheck to the given state, resetting all other aspects.
+// OnUp or OnDown is called if the state changed, use ResetNotify to call it regardless, or ResetQuiet to never call it.
 func (hrt *HealthChecker) Reset(newState bool) {
+	hrt.reset(newState, notifyOnChange)
+}
+
+// ResetNotify sets the healthcheck to the given state like Reset, and calls OnUp or OnDown for the new state
+// even if the state did not change.
+func (hrt *HealthChecker) ResetNotify(newState bool) {
+	hrt.reset(newState, notifyAlways)
+}
+
+// ResetQuiet sets the healthcheck to the given state like Reset, but without calling OnUp or OnDown.
+// It is appropriate when re-initializing, e.g. on config reload, where a callback would be a false transition.
+func (hrt *HealthChecker) ResetQuiet(newState bool) {
+	hrt.reset(newState, notifyNever)
+}
+
+// reset notification modes
+const (
+	notifyNever = iota
+	notifyOnChange
+	notifyAlways
+)
+
+func (hrt *HealthChecker) reset(newState bool, notify int) {
 	hrt.mu.Lock()
-	defer hrt.mu.Unlock()
-	var state int32
+	var ev Event
 	if newState {
-		state = 1
-		if hrt.OnUp != nil {
-			defer hrt.OnUp(hrt.ups, hrt.downs)
-		}
+		ev = hrt.event(EventUp, nil)
 	} else {
-		if hrt.OnDown != nil {
-			defer hrt.OnDown(hrt.ups, hrt.downs, hrt.lastErr)
-		}
+		ev = hrt.event(EventDown, hrt.lastErr)
 	}
-	atomic.StoreInt32(&hrt.state, state)
+	changed := hrt.computedUp() != newState
+	hrt.downSince = time.Time{}
+	hrt.downtime = 0
+	hrt.setState(newState)
 	hrt.ups = 0
 	hrt.downs = 0
 	hrt.firstRun = true
+	forced := atomic.LoadInt32(&hrt.forced) != forcedNone
+	hrt.mu.Unlock()
+	if forced || notify == notifyNever || (notify == notifyOnChange && !changed) {
+		return
+	}
+	hrt.emit(ev)
+}
+
+// setState records a state change, must be called with lock held.
+func (hrt *HealthChecker) setState(up bool) {
+	now := time.Now()
+	if up {
+		atomic.StoreInt32(&hrt.state, 1)
+		if !hrt.downSince.IsZero() {
+			hrt.downtime += now.Sub(hrt.downSince)
+			hrt.downSince = time.Time{}
+		}
+	} else {
+		atomic.StoreInt32(&hrt.state, 0)
+		if hrt.downSince.IsZero() {
+			hrt.downSince = now
+		}
+	}
+	hrt.lastChange = now
+	hrt.fastChange = false
+}
+
+// Start runs the health check periodically in its own IntervalRoutine, without retry backoff like HealthCheckRoutine.
+// It returns true if this call started the routine, false if it was already started.
+// For more control, e.g. over the routine options, use the HealthChecker as the Runner of an IntervalRoutine.
+func (hrt *HealthChecker) Start(runInterval time.Duration, retryInterval time.Duration) bool {
+	hrt.mu.Lock()
+	if hrt.routine != nil {
+		hrt.mu.Unlock()
+		return false
+	}
+	rt := NewIntervalRoutine(hrt, runInterval, retryInterval)
+	rt.RetryBackoffDisabled = true
+	rt.Jitter = hrt.ProbeJitter
+	rt.SetName(hrt.name)
+	hrt.routine = rt
+	hrt.mu.Unlock()
+	return rt.Start()
+}
+
+// Stop stops the routine created by Start, if any.
+func (hrt *HealthChecker) Stop() {
+	if rt := hrt.getRoutine(); rt != nil {
+		rt.Stop()
+	}
+}
+
+// Done returns a channel that is closed once the routine created by Start has exited.
+// It returns nil if Start was not called.
+func (hrt *HealthChecker) Done() <-chan struct{} {
+	if rt := hrt.getRoutine(); rt != nil {
+		return rt.Done()
+	}
+	return nil
+}
+
+// IsRunning returns true if the routine created by Start is currently running the check.
+func (hrt *HealthChecker) IsRunning() bool {
+	if rt := hrt.getRoutine(); rt != nil {
+		return rt.IsRunning()
+	}
+	return false
+}
+
+// AsRoutine returns the checker as a Routine whose Start calls Start with the given intervals,
+// e.g. to add it to a RoutineGroup. The same Routine must be used for the dependencies of the group.
+func (hrt *HealthChecker) AsRoutine(runInterval time.Duration, retryInterval time.Duration) Routine {
+	return &checkerRoutine{hc: hrt, runInterval: runInterval, retryInterval: retryInterval}
+}
+
+// checkerRoutine is a HealthChecker run by the routine created by its Start, see AsRoutine.
+type checkerRoutine struct {
+	hc            *HealthChecker
+	runInterval   time.Duration
+	retryInterval time.Duration
+}
+
+func (ckr *checkerRoutine) Start() bool {
+	return ckr.hc.Start(ckr.runInterval, ckr.retryInterval)
+}
+
+func (ckr *checkerRoutine) Stop() {
+	ckr.hc.Stop()
+}
+
+// StopAndWait stops the routine created by Start and waits for it to exit, it returns nil if not started.
+func (ckr *checkerRoutine) StopAndWait(ctx context.Context) error {
+	if rt := ckr.hc.getRoutine(); rt != nil {
+		return rt.StopAndWait(ctx)
+	}
+	return nil
+}
+
+func (ckr *checkerRoutine) TriggerRun() {
+	if rt := ckr.hc.getRoutine(); rt != nil {
+		rt.TriggerRun()
+	}
+}
+
+func (ckr *checkerRoutine) IsRunning() bool {
+	return ckr.hc.IsRunning()
+}
+
+func (ckr *checkerRoutine) Stopped() bool {
+	if rt := ckr.hc.getRoutine(); rt != nil {
+		return rt.Stopped()
+	}
+	return false
+}
+
+func (ckr *checkerRoutine) Done() <-chan struct{} {
+	return ckr.hc.Done()
+}
+
+func (ckr *checkerRoutine) Name() string {
+	return ckr.hc.Name()
+}
+
+func (ckr *checkerRoutine) CurrentInterval() time.Duration {
+	if rt := ckr.hc.getRoutine(); rt != nil {
+		return rt.CurrentInterval()
+	}
+	return 0
+}
+
+func (ckr *checkerRoutine) LastRunTime() time.Time {
+	if rt := ckr.hc.getRoutine(); rt != nil {
+		return rt.LastRunTime()
+	}
+	return time.Time{}
+}
+
+func (ckr *checkerRoutine) LastErr() error {
+	return ckr.hc.LastErr()
+}
+
+// firstSuccess returns a channel closed after the first successful check, see RoutineGroup.WaitForDeps.
+func (ckr *checkerRoutine) firstSuccess() <-chan struct{} {
+	if rt := ckr.hc.getRoutine(); rt != nil {
+		return rt.firstSuccess()
+	}
+	return nil
+}
+
+func (hrt *HealthChecker) getRoutine() *IntervalRoutine {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	return hrt.routine
 }
 
 // IntervalRun implements the Runner interface
 func (hrt *HealthChecker) IntervalRun() error {
-	err := hrt.runner.IntervalRun()
+	return hrt.IntervalRunCtx(context.Background())
+}
 
+// IntervalRunCtx implements the RunnerCtx interface, the context is passed to the check.
+// A check failing once ctx is done, e.g. when the routine stops, is not recorded.
+func (hrt *HealthChecker) IntervalRunCtx(ctx context.Context) error {
+	_, err := hrt.runCheck(ctx)
+	return err
+}
+
+// CheckNow runs the check once synchronously and returns the resulting state and the error of the check.
+// The run counts toward the thresholds like any other, and is serialized with runs from a routine.
+// A panic of the check is recorded as a failure, then propagated to the caller as a *PanicError.
+func (hrt *HealthChecker) CheckNow() (up bool, err error) {
+	return hrt.runCheck(context.Background())
+}
+
+// Report records the outcome of an external observation, e.g. from an event-driven source,
+// which counts toward the thresholds like a run. In this push mode no Runner is needed.
+func (hrt *HealthChecker) Report(err error) {
+	hrt.runMu.Lock()
+	defer hrt.runMu.Unlock()
+	hrt.record(probe{err: err})
+}
+
+func (hrt *HealthChecker) runCheck(ctx context.Context) (bool, error) {
+	// one run at a time, so that the returned state is the outcome of this run
+	hrt.runMu.Lock()
+	defer hrt.runMu.Unlock()
+	p := hrt.check(ctx)
+	if p.err != nil && !p.panicked && ctx.Err() != nil {
+		// the check was cancelled, e.g. on Stop, which says nothing about health
+		return hrt.IsUp(), p.err
+	}
+	hrt.record(p)
+	if p.panicked {
+		// recorded as a failure, then propagated so that a wrapping routine handles it as usual,
+		// as the PanicError keeping the stack of the check
+		panic(p.err)
+	}
+	return hrt.IsUp(), p.err
+}
+
+// record updates the state with the outcome of a check, then calls the callbacks.
+func (hrt *HealthChecker) record(p probe) {
+	err := p.err
+	var events []Event
 	hrt.mu.Lock()
-	faststart := hrt.FastStart && hrt.firstRun
-	wasUp := hrt.IsUp()
-	if err != nil {
+	if p.scored {
+		hrt.score = p.score
+	} else if hrt.isFailure(err) {
+		hrt.score = 0
+	} else {
+		hrt.score = 1
+	}
+	hrt.details = p.details
+	fastUp := hrt.firstRun && (hrt.FastStart || hrt.FastStartUp)
+	fastDown := hrt.firstRun && (hrt.FastStart || hrt.FastStartDown)
+	wasUp := hrt.computedUp()
+	forced := atomic.LoadInt32(&hrt.forced) != forcedNone
+	// too early to leave the current state, counts keep accumulating
+	held := hrt.MinStateDuration > 0 && time.Since(hrt.lastChange) < hrt.MinStateDuration
+	if hrt.isFailure(err) {
 		hrt.downs++
+		events = append(events, hrt.event(runEventType(err), err))
 		if !wasUp {
 			// clear any progress
 			hrt.ups = 0
-		} else if faststart || hrt.downs >= hrt.thresholdDown {
+		} else if fastDown || (hrt.downs >= hrt.thresholdDown && !held) {
 			// going down
-			atomic.StoreInt32(&hrt.state, 0)
-			if hrt.OnDown != nil {
-				defer hrt.OnDown(hrt.ups, hrt.downs, err)
+			hrt.setState(false)
+			hrt.fastChange = hrt.downs < hrt.thresholdDown
+			if !forced {
+				events = append(events, hrt.event(EventDown, err))
 			}
 			hrt.ups = 0
 		}
 		hrt.lastErr = err
 	} else {
+		if err != nil {
+			// not a failure, but keep track of it
+			hrt.lastErr = err
+		}
 		hrt.ups++
+		events = append(events, hrt.event(EventRunOK, err))
 		if wasUp {
 			// clear any progress
 			hrt.downs = 0
-		} else if faststart || hrt.ups >= hrt.thresholdUp {
+		} else if fastUp || (hrt.ups >= hrt.thresholdUp && !held) {
 			// going up
-			atomic.StoreInt32(&hrt.state, 1)
-			if hrt.OnUp != nil {
-				defer hrt.OnUp(hrt.ups, hrt.downs)
+			hrt.setState(true)
+			hrt.fastChange = hrt.ups < hrt.thresholdUp
+			if !forced {
+				events = append(events, hrt.event(EventUp, nil))
 			}
 			hrt.downs = 0
 		}
 	}
 	hrt.firstRun = false
-	// unlock manually so that defers are lock-less
+	// unlock before callbacks so that they are lock-less
 	hrt.mu.Unlock()
-	return err
+	for _, ev := range events {
+		hrt.emit(ev)
+	}
+}
+
+// event returns an event with the current counts, must be called with lock held.
+func (hrt *HealthChecker) event(typ EventType, err error) Event {
+	return Event{
+		Type:  typ,
+		Time:  time.Now(),
+		Name:  hrt.name,
+		Ups:   hrt.ups,
+		Downs: hrt.downs,
+		Err:   err,
+		// only meaningful for transitions
+		FastStart: (typ == EventUp || typ == EventDown) && hrt.fastChange,
+	}
+}
+
+// emit calls the callbacks for an event, must be called without lock held.
+func (hrt *HealthChecker) emit(ev Event) {
+	switch ev.Type {
+	case EventUp:
+		if hrt.OnUp != nil {
+			hrt.OnUp(ev.Ups, ev.Downs)
+		}
+	case EventDown:
+		if hrt.OnDown != nil {
+			hrt.OnDown(ev.Ups, ev.Downs, ev.Err)
+		}
+	case EventRunError, EventPanic:
+		if hrt.OnError != nil {
+			hrt.OnError(ev.Err, ev.Downs)
+		}
+	}
+	if hrt.OnEvent != nil {
+		hrt.OnEvent(ev)
+	}
+	if ev.Type == EventUp || ev.Type == EventDown {
+		hrt.mu.RLock()
+		listeners := hrt.listeners
+		hrt.mu.RUnlock()
+		for _, l := range listeners {
+			l.call(ev)
+		}
+	}
+}
+
+// listener is a transition listener, a pointer so that it can be removed.
+type listener struct {
+	f func(ev Event)
+}
+
+// call calls the listener, recovering a panic so that other listeners are still called.
+func (l *listener) call(ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			prefix := ""
+			if ev.Name != "" {
+				prefix = ev.Name + ": "
+			}
+			fmt.Printf("%slistener recovered: %v, stack: %s\n", prefix, r, debug.Stack())
+		}
+	}()
+	l.f(ev)
+}
+
+// AddOnUp adds a listener called when state changes to up, like OnUp, e.g. for several subsystems to react independently.
+// Listeners are called after OnUp, OnDown and OnEvent, in the order they were added, without lock held.
+// A panic in a listener is recovered and printed, the other listeners are still called.
+// The returned function removes the listener.
+func (hrt *HealthChecker) AddOnUp(f func(numUps int, numDowns int)) func() {
+	return hrt.addListener(func(ev Event) {
+		if ev.Type == EventUp {
+			f(ev.Ups, ev.Downs)
+		}
+	})
+}
+
+// AddOnDown adds a listener called when state changes to down, like OnDown, see AddOnUp.
+// The returned function removes the listener.
+func (hrt *HealthChecker) AddOnDown(f func(numUps int, numDowns int, lastErr error)) func() {
+	return hrt.addListener(func(ev Event) {
+		if ev.Type == EventDown {
+			f(ev.Ups, ev.Downs, ev.Err)
+		}
+	})
+}
+
+// subscribe adds an internal listener called on each state transition, after the callbacks.
+func (hrt *HealthChecker) subscribe(f func(up bool)) {
+	hrt.addListener(func(ev Event) {
+		f(ev.Type == EventUp)
+	})
+}
+
+// addListener adds a listener called on each state transition, it returns a function removing it.
+func (hrt *HealthChecker) addListener(f func(ev Event)) func() {
+	l := &listener{f: f}
+	hrt.mu.Lock()
+	defer hrt.mu.Unlock()
+	// copy on write, emit iterates without lock
+	listeners := make([]*listener, len(hrt.listeners), len(hrt.listeners)+1)
+	copy(listeners, hrt.listeners)
+	hrt.listeners = append(listeners, l)
+	return func() {
+		hrt.mu.Lock()
+		defer hrt.mu.Unlock()
+		listeners := make([]*listener, 0, len(hrt.listeners))
+		for _, other := range hrt.listeners {
+			if other != l {
+				listeners = append(listeners, other)
+			}
+		}
+		hrt.listeners = listeners
+	}
+}
+
+func (hrt *HealthChecker) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return hrt.IsFailure == nil || hrt.IsFailure(err)
+}
+
+// HealthSnapshot is a copy of the full state of a HealthChecker at a point in time.
+type HealthSnapshot struct {
+	Up    bool `json:"up"`
+	Ups   int  `json:"ups"`
+	Downs int  `json:"downs"`
+	// LastErr is the last error recorded, LastError is its message for serialization
+	LastErr            error     `json:"-"`
+	LastError          string    `json:"lastError,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+	ThresholdUp        int       `json:"thresholdUp"`
+	ThresholdDown      int       `json:"thresholdDown"`
+	// Details are the details of the last check, see DetailedRunner
+	Details map[string]interface{} `json:"details,omitempty"`
+	// FastStartTransition is true if the last transition was caused by fast start, see LastTransitionWasFastStart
+	FastStartTransition bool `json:"fastStartTransition,omitempty"`
+}
+
+// LastTransitionWasFastStart returns true if the last state transition was caused by fast start on the first run,
+// rather than by reaching a threshold, e.g. to avoid alerting on warm-up transitions.
+func (hrt *HealthChecker) LastTransitionWasFastStart() bool {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	return hrt.fastChange
+}
+
+// Snapshot returns the current state, all fields are captured consistently.
+func (hrt *HealthChecker) Snapshot() HealthSnapshot {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	snap := HealthSnapshot{
+		Up:                  hrt.IsUp(),
+		Ups:                 hrt.ups,
+		Downs:               hrt.downs,
+		LastErr:             hrt.lastErr,
+		LastTransitionTime:  hrt.lastChange,
+		FastStartTransition: hrt.fastChange,
+		ThresholdUp:         hrt.thresholdUp,
+		ThresholdDown:       hrt.thresholdDown,
+		Details:             hrt.details,
+	}
+	if hrt.lastErr != nil {
+		snap.LastError = hrt.lastErr.Error()
+	}
+	return snap
+}
+
+// ErrStale is the error recorded by a freshness health check when the routine has not run recently.
+var ErrStale = errors.New("routine is stale")
+
+// persistedState is the state saved by MarshalState, serialized as JSON, e.g.
+// {"up":true,"ups":3,"downs":0,"lastError":"timeout","lastTransitionTime":"2020-01-02T15:04:05Z"}
+// Up is the computed state, Forced the state set by ForceState if any.
+type persistedState struct {
+	Up                 bool      `json:"up"`
+	Forced             *bool     `json:"forced,omitempty"`
+	Ups                int       `json:"ups"`
+	Downs              int       `json:"downs"`
+	LastError          string    `json:"lastError,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// MarshalState returns the state and counts as JSON, to be saved and given to RestoreState after a restart.
+// A state forced with ForceState is saved separately from the computed state, and restored as forced.
+func (hrt *HealthChecker) MarshalState() ([]byte, error) {
+	// one lock, so that the counts and states are of the same run
+	hrt.mu.RLock()
+	ps := persistedState{
+		Up:                 hrt.computedUp(),
+		Ups:                hrt.ups,
+		Downs:              hrt.downs,
+		LastTransitionTime: hrt.lastChange,
+	}
+	if hrt.lastErr != nil {
+		ps.LastError = hrt.lastErr.Error()
+	}
+	switch atomic.LoadInt32(&hrt.forced) {
+	case forcedUp:
+		up := true
+		ps.Forced = &up
+	case forcedDown:
+		up := false
+		ps.Forced = &up
+	}
+	hrt.mu.RUnlock()
+	return json.Marshal(ps)
+}
+
+// RestoreState sets the state and counts saved by MarshalState, without calling any callback.
+// The restored state is not a first run, so FastStart does not apply, e.g. to avoid flapping on a rolling restart.
+// The last error is restored with its message only.
+func (hrt *HealthChecker) RestoreState(data []byte) error {
+	var ps persistedState
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return fmt.Errorf("invalid health state: %w", err)
+	}
+	if ps.Ups < 0 || ps.Downs < 0 {
+		return fmt.Errorf("invalid health state: negative counts %d / %d", ps.Ups, ps.Downs)
+	}
+
+	hrt.mu.Lock()
+	defer hrt.mu.Unlock()
+	hrt.downSince = time.Time{}
+	hrt.downtime = 0
+	hrt.setState(ps.Up)
+	if !ps.LastTransitionTime.IsZero() {
+		hrt.lastChange = ps.LastTransitionTime
+	}
+	hrt.ups = ps.Ups
+	hrt.downs = ps.Downs
+	forced := int32(forcedNone)
+	if ps.Forced != nil {
+		forced = forcedDown
+		if *ps.Forced {
+			forced = forcedUp
+		}
+	}
+	atomic.StoreInt32(&hrt.forced, forced)
+	hrt.lastErr = nil
+	if ps.LastError != "" {
+		hrt.lastErr = errors.New(ps.LastError)
+	}
+	hrt.firstRun = false
+	return nil
+}
+
+// NewFreshnessHealthChecker creates a HealthChecker acting as a watchdog for an IntervalRoutine.
+// It is up if the routine completed a run within maxStaleness and that run succeeded, down otherwise.
+// It starts down, and uses thresholds of 1, it still needs to be run at interval, e.g. by another IntervalRoutine.
+func NewFreshnessHealthChecker(rt *IntervalRoutine, maxStaleness time.Duration) *HealthChecker {
+	return NewHealthChecker(RunnerFunc(func() error {
+		last := rt.LastRunTime()
+		if last.IsZero() || time.Since(last) > maxStaleness {
+			return ErrStale
+		}
+		return rt.LastErr()
+	}), false, 1, 1)
+}
+
+// SetName sets the name of the health check, to tell checks apart.
+func (hrt *HealthChecker) SetName(name string) {
+	hrt.mu.Lock()
+	defer hrt.mu.Unlock()
+	hrt.name = name
+	if hrt.routine != nil {
+		hrt.routine.SetName(name)
+	}
+}
+
+// Name returns the name of the health check, empty by default.
+func (hrt *HealthChecker) Name() string {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	return hrt.name
 }
 
 // IsUp returns the current state, up (true) or down (false)
 func (hrt *HealthChecker) IsUp() bool {
+	switch atomic.LoadInt32(&hrt.forced) {
+	case forcedUp:
+		return true
+	case forcedDown:
+		return false
+	}
+	return hrt.computedUp()
+}
+
+// computedUp returns the state computed from the checks, ignoring any forced state.
+func (hrt *HealthChecker) computedUp() bool {
 	return atomic.LoadInt32(&hrt.state) == 1
 }
 
+// ForceState forces the reported state, e.g. down to drain a node during maintenance.
+// While forced, checks keep running and updating the computed state, but IsUp returns the forced state
+// and OnUp / OnDown are only called for changes of the reported state.
+func (hrt *HealthChecker) ForceState(up bool) {
+	f := int32(forcedDown)
+	if up {
+		f = forcedUp
+	}
+	hrt.setForced(f)
+}
+
+// ClearForcedState releases a state forced by ForceState, the reported state is the computed state again.
+func (hrt *HealthChecker) ClearForcedState() {
+	hrt.setForced(forcedNone)
+}
+
+func (hrt *HealthChecker) setForced(f int32) {
+	hrt.mu.Lock()
+	wasUp := hrt.IsUp()
+	atomic.StoreInt32(&hrt.forced, f)
+	up := hrt.IsUp()
+	var ev Event
+	if up {
+		ev = hrt.event(EventUp, nil)
+	} else {
+		ev = hrt.event(EventDown, hrt.lastErr)
+	}
+	hrt.mu.Unlock()
+	if up != wasUp {
+		hrt.emit(ev)
+	}
+}
+
 // LastErr returns the last error
 func (hrt *HealthChecker) LastErr() error {
 	hrt.mu.RLock()
 	defer hrt.mu.RUnlock()
 	return hrt.lastErr
 }
+
+// Score returns the score of the last run.
+// For a ScoringRunner it is the returned score, otherwise 1.0 on success and 0.0 on failure.
+func (hrt *HealthChecker) Score() float64 {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	return hrt.score
+}
+
+// ProgressUp returns the consecutive successes toward going up while down, out of thresholdUp, 0 while up.
+// It is the Ups count of Snapshot while down.
+func (hrt *HealthChecker) ProgressUp() int {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	if hrt.computedUp() {
+		return 0
+	}
+	return hrt.ups
+}
+
+// ProgressDown returns the consecutive failures toward going down while up, out of thresholdDown, 0 while down.
+// It is the Downs count of Snapshot while up.
+func (hrt *HealthChecker) ProgressDown() int {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	if !hrt.computedUp() {
+		return 0
+	}
+	return hrt.downs
+}
+
+// Details returns the details of the last check, nil unless the runner is a DetailedRunner.
+// The returned map must not be modified.
+func (hrt *HealthChecker) Details() map[string]interface{} {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	return hrt.details
+}
+
+// DowntimeTotal returns the total time spent down since creation or last Reset, including any ongoing downtime.
+func (hrt *HealthChecker) DowntimeTotal() time.Duration {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	total := hrt.downtime
+	if !hrt.downSince.IsZero() {
+		total += time.Since(hrt.downSince)
+	}
+	return total
+}
+
+// CurrentDowntime returns the time spent down in the ongoing downtime, 0 if up.
+func (hrt *HealthChecker) CurrentDowntime() time.Duration {
+	hrt.mu.RLock()
+	defer hrt.mu.RUnlock()
+	if hrt.downSince.IsZero() {
+		return 0
+	}
+	return time.Since(hrt.downSince)
+}
+
+// forced states, see ForceState
+const (
+	forcedNone = iota
+	forcedDown
+	forcedUp
+)
+
+// probe is the outcome of a check.
+type probe struct {
+	err     error
+	score   float64
+	scored  bool
+	details map[string]interface{}
+	// panicked is set if the check panicked with recovered, err is then a PanicError
+	panicked  bool
+	recovered interface{}
+}
+
+// check runs the health function, bounded by CheckTimeout if set.
+func (hrt *HealthChecker) check(ctx context.Context) (p probe) {
+	if hrt.CheckTimeout <= 0 {
+		if !hrt.NoRecover {
+			defer func() {
+				if r := recover(); r != nil {
+					p = probe{err: &PanicError{Value: r, Stack: debug.Stack()}, panicked: true, recovered: r}
+				}
+			}()
+		}
+		return hrt.call(ctx)
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, hrt.CheckTimeout)
+	defer cancel()
+	res := make(chan probe, 1)
+	go func() {
+		r := probe{panicked: true}
+		defer func() {
+			if r.panicked {
+				// forward the panic to the calling goroutine
+				r.recovered = recover()
+				r.err = &PanicError{Value: r.recovered, Stack: debug.Stack()}
+			}
+			res <- r
+		}()
+		r = hrt.call(ctx)
+	}()
+
+	select {
+	case r := <-res:
+		if r.panicked && hrt.NoRecover {
+			panic(r.recovered)
+		}
+		return r
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			// cancelled by the caller, e.g. the routine stopping, not recorded
+			return probe{err: err}
+		}
+		// the check is hung, count it as a failure
+		return probe{err: ctx.Err()}
+	}
+}
+
+func (hrt *HealthChecker) call(ctx context.Context) probe {
+	if sr, ok := hrt.runner.(ScoringRunner); ok {
+		score, err := sr.IntervalScore()
+		if err == nil && score < hrt.minScore() {
+			err = ErrLowScore
+		}
+		return probe{err: err, score: score, scored: true}
+	}
+	if dr, ok := hrt.runner.(DetailedRunner); ok {
+		details, err := dr.CheckDetailed()
+		return probe{err: err, details: details}
+	}
+	if rc, ok := hrt.runner.(RunnerCtx); ok {
+		return probe{err: rc.IntervalRunCtx(ctx)}
+	}
+	return probe{err: hrt.runner.IntervalRun()}
+}
+
+func (hrt *HealthChecker) minScore() float64 {
+	if hrt.MinScore == 0 {
+		return 0.5
+	}
+	return hrt.MinScore
+}
@@ -2,6 +2,10 @@ package goodroutine
 
 import "testing"
 import "errors"
+import "context"
+import "time"
+import "sync/atomic"
+import "strings"
 
 func TestHealthChecker(t *testing.T) {
 	type testRun struct {
@@ -132,3 +136,843 @@ func testStateChange(t *testing.T, hc *HealthChecker, checkErr error, numRuns in
 		t.Errorf("Callback not called")
 	}
 }
+
+type sleepProbe time.Duration
+
+func (p sleepProbe) IntervalRun() error {
+	time.Sleep(time.Duration(p))
+	return nil
+}
+
+type ctxProbe time.Duration
+
+func (p ctxProbe) IntervalRun() error {
+	return p.IntervalRunCtx(context.Background())
+}
+
+func (p ctxProbe) IntervalRunCtx(ctx context.Context) error {
+	select {
+	case <-time.After(time.Duration(p)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		runner Runner
+	}{
+		{"plain", sleepProbe(time.Second)},
+		{"ctx", ctxProbe(time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := NewHealthChecker(tt.runner, true, 1, 2)
+			hc.FastStart = false
+			hc.CheckTimeout = 10 * time.Millisecond
+
+			for i := 0; i < 2; i++ {
+				start := time.Now()
+				err := hc.IntervalRun()
+				if g, w := err, context.DeadlineExceeded; g != w {
+					t.Errorf("Error does not match, got=%v, want=%v", g, w)
+				}
+				if d := time.Since(start); d > 500*time.Millisecond {
+					t.Errorf("Check was not bounded by timeout, took %v", d)
+				}
+			}
+			if hc.IsUp() {
+				t.Errorf("Timed out checks should count as down")
+			}
+		})
+	}
+
+	hc := NewHealthChecker(ctxProbe(time.Millisecond), false, 1, 1)
+	hc.CheckTimeout = time.Second
+	if err := hc.IntervalRun(); err != nil {
+		t.Errorf("Unexpected error, got=%v", err)
+	}
+	if !hc.IsUp() {
+		t.Errorf("Check within timeout should count as up")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	checkErr := errors.New("error")
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 2, 2)
+	hc.FastStart = false
+	before := time.Now()
+	hc.IntervalRun()
+	hc.IntervalRun()
+
+	snap := hc.Snapshot()
+	if snap.Up {
+		t.Errorf("State should be down")
+	}
+	if g, w := snap.Downs, 2; g != w {
+		t.Errorf("Incorrect downs, got=%v, want=%v", g, w)
+	}
+	if g, w := snap.LastErr, checkErr; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+	if g, w := snap.LastError, "error"; g != w {
+		t.Errorf("Incorrect error message, got=%v, want=%v", g, w)
+	}
+	if snap.LastTransitionTime.Before(before) {
+		t.Errorf("Transition time not updated, got=%v", snap.LastTransitionTime)
+	}
+	if g, w := snap.ThresholdDown, 2; g != w {
+		t.Errorf("Incorrect threshold, got=%v, want=%v", g, w)
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	warning := errors.New("warning")
+	failure := errors.New("failure")
+	var checkErr error
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 1, 2)
+	hc.FastStart = false
+	hc.IsFailure = func(err error) bool {
+		return err != warning
+	}
+
+	checkErr = warning
+	for i := 0; i < 5; i++ {
+		if g, w := hc.IntervalRun(), warning; g != w {
+			t.Errorf("Error does not match, got=%v, want=%v", g, w)
+		}
+	}
+	if snap := hc.Snapshot(); !snap.Up || snap.Downs != 0 || snap.LastErr != warning {
+		t.Errorf("Warnings should not count as failures, got=%+v", snap)
+	}
+
+	checkErr = failure
+	hc.IntervalRun()
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Errorf("Failures should count as down")
+	}
+}
+
+func TestDowntime(t *testing.T) {
+	var checkErr error
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 1, 1)
+	if g := hc.DowntimeTotal(); g != 0 {
+		t.Errorf("Downtime should be 0, got=%v", g)
+	}
+
+	checkErr = errors.New("error")
+	hc.IntervalRun()
+	time.Sleep(20 * time.Millisecond)
+	if g := hc.CurrentDowntime(); g < 20*time.Millisecond {
+		t.Errorf("Current downtime too short, got=%v", g)
+	}
+
+	checkErr = nil
+	hc.IntervalRun()
+	if g := hc.CurrentDowntime(); g != 0 {
+		t.Errorf("Current downtime should be 0 when up, got=%v", g)
+	}
+	total := hc.DowntimeTotal()
+	if total < 20*time.Millisecond {
+		t.Errorf("Total downtime too short, got=%v", total)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if g, w := hc.DowntimeTotal(), total; g != w {
+		t.Errorf("Total downtime should not grow when up, got=%v, want=%v", g, w)
+	}
+
+	hc.Reset(true)
+	if g := hc.DowntimeTotal(); g != 0 {
+		t.Errorf("Downtime should be 0 after reset, got=%v", g)
+	}
+}
+
+func TestPanicCheck(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Second} {
+		hc := NewHealthChecker(RunnerFunc(func() error {
+			panic("blah")
+		}), true, 1, 1)
+		hc.CheckTimeout = timeout
+		var lastErr error
+		hc.OnDown = func(numUps int, numDowns int, err error) {
+			lastErr = err
+		}
+		rt := NewIntervalRoutine(hc, time.Hour, 0)
+		var recovered interface{}
+		rt.OnPanic = func(r interface{}) {
+			recovered = r
+		}
+
+		// the panic is recorded, then reaches the routine
+		panicked, err := rt.run(rt.runner)
+		if !panicked || recovered != "blah" {
+			t.Errorf("Routine should recover the panic, got=%v, %v", panicked, recovered)
+		}
+		if value, _, _ := rt.LastPanic(); value != "blah" {
+			t.Errorf("Incorrect last panic, got=%v", value)
+		}
+		if _, ok := err.(*PanicError); !ok {
+			t.Errorf("Error should be a PanicError, got=%v", err)
+		}
+		pe, ok := lastErr.(*PanicError)
+		if !ok {
+			t.Fatalf("Recorded error should be a PanicError, got=%v", lastErr)
+		}
+		if g, w := pe.Value, "blah"; g != w {
+			t.Errorf("Panic value does not match, got=%v, want=%v", g, w)
+		}
+		// the routine keeps the stack of the check
+		if _, stack, _ := rt.LastPanic(); string(stack) != string(pe.Stack) || !strings.Contains(string(stack), "TestPanicCheck.func") {
+			t.Errorf("Routine stack should be the one of the check, got=%s", stack)
+		}
+		if hc.IsUp() {
+			t.Errorf("Panics should count as down")
+		}
+	}
+}
+
+type scoreProbe float64
+
+func (p *scoreProbe) IntervalRun() error {
+	return nil
+}
+
+func (p *scoreProbe) IntervalScore() (float64, error) {
+	return float64(*p), nil
+}
+
+func TestScoringRunner(t *testing.T) {
+	score := scoreProbe(0.9)
+	hc := NewHealthChecker(&score, false, 2, 2)
+	hc.FastStart = false
+	hc.MinScore = 0.7
+
+	hc.IntervalRun()
+	hc.IntervalRun()
+	if !hc.IsUp() {
+		t.Errorf("High score should count as up")
+	}
+	if g, w := hc.Score(), 0.9; g != w {
+		t.Errorf("Score does not match, got=%v, want=%v", g, w)
+	}
+
+	score = 0.5
+	if g, w := hc.IntervalRun(), ErrLowScore; g != w {
+		t.Errorf("Error does not match, got=%v, want=%v", g, w)
+	}
+	if !hc.IsUp() {
+		t.Errorf("Threshold should apply to low scores")
+	}
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Errorf("Low score should count as down")
+	}
+	if g, w := hc.Score(), 0.5; g != w {
+		t.Errorf("Score does not match, got=%v, want=%v", g, w)
+	}
+}
+
+func TestResetQuiet(t *testing.T) {
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return nil
+	}), false, 1, 1)
+	calls := 0
+	hc.OnUp = func(numUps int, numDowns int) {
+		calls++
+	}
+	hc.OnDown = func(numUps int, numDowns int, lastErr error) {
+		calls++
+	}
+
+	hc.ResetQuiet(true)
+	if !hc.IsUp() {
+		t.Errorf("State should be up")
+	}
+	hc.ResetQuiet(false)
+	if hc.IsUp() {
+		t.Errorf("State should be down")
+	}
+	if calls != 0 {
+		t.Errorf("Callbacks should not be called, got=%d", calls)
+	}
+	hc.Reset(true)
+	if calls != 1 {
+		t.Errorf("Callback should be called, got=%d", calls)
+	}
+}
+
+func TestFreshnessHealthChecker(t *testing.T) {
+	var checkErr error
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return checkErr
+	}), 0, 0)
+	hc := NewFreshnessHealthChecker(rt, 20*time.Millisecond)
+
+	if g, w := hc.IntervalRun(), ErrStale; g != w {
+		t.Errorf("Error does not match, got=%v, want=%v", g, w)
+	}
+	rt.Start()
+	defer rt.Stop()
+	rt.WaitForFirstRun(context.Background())
+	if err := hc.IntervalRun(); err != nil || !hc.IsUp() {
+		t.Errorf("Fresh routine should be up, got=%v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if g, w := hc.IntervalRun(), ErrStale; g != w || hc.IsUp() {
+		t.Errorf("Stale routine should be down, got=%v, want=%v", g, w)
+	}
+
+	checkErr = errors.New("error")
+	rt.TriggerRun()
+	time.Sleep(5 * time.Millisecond)
+	if g, w := hc.IntervalRun(), checkErr; g != w || hc.IsUp() {
+		t.Errorf("Failing routine should be down, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOnEvent(t *testing.T) {
+	checkErr := errors.New("error")
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 1, 2)
+	hc.FastStart = false
+	hc.SetName("db")
+	var events []Event
+	hc.OnEvent = func(ev Event) {
+		events = append(events, ev)
+	}
+	downs := 0
+	hc.OnDown = func(numUps int, numDowns int, lastErr error) {
+		downs++
+	}
+
+	hc.IntervalRun()
+	hc.IntervalRun()
+	checkErr = nil
+	hc.IntervalRun()
+
+	want := []EventType{EventRunError, EventRunError, EventDown, EventRunOK, EventUp}
+	if len(events) != len(want) {
+		t.Fatalf("Incorrect events, got=%v, want=%v", events, want)
+	}
+	for i, w := range want {
+		if g := events[i].Type; g != w {
+			t.Errorf("Event does not match at i=%d, got=%v, want=%v", i, g, w)
+		}
+		if g, w := events[i].Name, "db"; g != w {
+			t.Errorf("Name does not match, got=%v, want=%v", g, w)
+		}
+	}
+	if g, w := events[2].Downs, 2; g != w {
+		t.Errorf("Incorrect downs, got=%v, want=%v", g, w)
+	}
+	if downs != 1 {
+		t.Errorf("OnDown should still be called, got=%d", downs)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var checkErr error
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 1, 3)
+	hc.FastStart = false
+	var errs []error
+	var downs []int
+	hc.OnError = func(err error, numDowns int) {
+		errs = append(errs, err)
+		downs = append(downs, numDowns)
+	}
+
+	err1 := errors.New("dependency 1")
+	err2 := errors.New("dependency 2")
+	checkErr = err1
+	hc.IntervalRun()
+	checkErr = err2
+	hc.IntervalRun()
+	checkErr = nil
+	hc.IntervalRun()
+
+	if len(errs) != 2 || errs[0] != err1 || errs[1] != err2 {
+		t.Errorf("Incorrect errors, got=%v", errs)
+	}
+	if len(downs) != 2 || downs[0] != 1 || downs[1] != 2 {
+		t.Errorf("Incorrect downs, got=%v", downs)
+	}
+	if !hc.IsUp() {
+		t.Errorf("State should not have changed")
+	}
+}
+
+func TestHealthCheckerStart(t *testing.T) {
+	up := make(chan bool, 1)
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return nil
+	}), false, 1, 1)
+	hc.OnUp = func(numUps int, numDowns int) {
+		up <- true
+	}
+	if hc.Done() != nil || hc.IsRunning() {
+		t.Error("routine should not exist before Start")
+	}
+	if !hc.Start(time.Hour, 0) {
+		t.Error("Start should return true")
+	}
+	if hc.Start(time.Hour, 0) {
+		t.Error("second Start should return false")
+	}
+
+	select {
+	case <-up:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("check did not go up")
+	}
+
+	hc.Stop()
+	select {
+	case <-hc.Done():
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("routine did not exit")
+	}
+}
+
+func TestCheckNow(t *testing.T) {
+	var checkErr error
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), false, 2, 1)
+	hc.FastStart = false
+
+	up, err := hc.CheckNow()
+	if up || err != nil {
+		t.Errorf("Incorrect result, got=%v, %v", up, err)
+	}
+	up, err = hc.CheckNow()
+	if !up || err != nil {
+		t.Errorf("Incorrect result, got=%v, %v", up, err)
+	}
+
+	checkErr = errors.New("error")
+	up, err = hc.CheckNow()
+	if up || err != checkErr {
+		t.Errorf("Incorrect result, got=%v, %v", up, err)
+	}
+}
+
+func TestFastStartUpOnly(t *testing.T) {
+	var checkErr error
+	runner := RunnerFunc(func() error {
+		return checkErr
+	})
+
+	// first run errors but stays up until thresholdDown
+	hc := NewHealthChecker(runner, true, 3, 2)
+	hc.FastStart = false
+	hc.FastStartUp = true
+	checkErr = errors.New("error")
+	hc.IntervalRun()
+	if !hc.IsUp() {
+		t.Error("check should still be up")
+	}
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Error("check should be down")
+	}
+
+	// first success goes up right away
+	hc = NewHealthChecker(runner, false, 3, 2)
+	hc.FastStart = false
+	hc.FastStartUp = true
+	checkErr = nil
+	hc.IntervalRun()
+	if !hc.IsUp() {
+		t.Error("check should be up")
+	}
+}
+
+type detailedRunner struct{}
+
+func (dr detailedRunner) IntervalRun() error {
+	return nil
+}
+
+func (dr detailedRunner) CheckDetailed() (map[string]interface{}, error) {
+	return map[string]interface{}{"version": "1.2"}, nil
+}
+
+func TestDetails(t *testing.T) {
+	hc := NewHealthChecker(detailedRunner{}, false, 1, 1)
+	hc.IntervalRun()
+	if !hc.IsUp() {
+		t.Error("check should be up")
+	}
+	if g, w := hc.Details()["version"], "1.2"; g != w {
+		t.Errorf("Incorrect details, got=%v, want=%v", g, w)
+	}
+	if g, w := hc.Snapshot().Details["version"], "1.2"; g != w {
+		t.Errorf("Incorrect snapshot details, got=%v, want=%v", g, w)
+	}
+}
+
+func TestForceState(t *testing.T) {
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return nil
+	}), false, 1, 1)
+	var transitions []bool
+	hc.OnUp = func(numUps int, numDowns int) {
+		transitions = append(transitions, true)
+	}
+	hc.OnDown = func(numUps int, numDowns int, lastErr error) {
+		transitions = append(transitions, false)
+	}
+
+	hc.ForceState(false)
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Error("check should be forced down")
+	}
+	if len(transitions) != 0 {
+		t.Errorf("No transition expected while forced, got=%v", transitions)
+	}
+
+	hc.ClearForcedState()
+	if !hc.IsUp() {
+		t.Error("check should be up once released")
+	}
+	hc.ForceState(false)
+	if hc.IsUp() {
+		t.Error("check should be forced down")
+	}
+	if len(transitions) != 2 || !transitions[0] || transitions[1] {
+		t.Errorf("Incorrect transitions, got=%v", transitions)
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	checkErr := errors.New("timeout")
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 2, 3)
+	hc.FastStart = false
+	hc.IntervalRun()
+	data, err := hc.MarshalState()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	called := false
+	restored := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), false, 2, 3)
+	restored.OnUp = func(numUps int, numDowns int) {
+		called = true
+	}
+	if err := restored.RestoreState(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if called {
+		t.Error("RestoreState should not call callbacks")
+	}
+	snap := restored.Snapshot()
+	if !snap.Up || snap.Downs != 1 || snap.LastError != "timeout" {
+		t.Errorf("Incorrect restored state, got=%+v", snap)
+	}
+	// no fast start after restore
+	restored.IntervalRun()
+	if !restored.IsUp() {
+		t.Error("check should still be up")
+	}
+
+	if err := restored.RestoreState([]byte(`{"ups":-1}`)); err == nil {
+		t.Error("RestoreState should fail on negative counts")
+	}
+	if err := restored.RestoreState([]byte(`{`)); err == nil {
+		t.Error("RestoreState should fail on invalid json")
+	}
+}
+
+func TestBoolRunnerFunc(t *testing.T) {
+	healthy := true
+	hc := NewHealthChecker(BoolRunnerFunc(func() bool {
+		return healthy
+	}), false, 1, 1)
+	var downErr error
+	hc.OnDown = func(numUps int, numDowns int, lastErr error) {
+		downErr = lastErr
+	}
+	hc.IntervalRun()
+	if !hc.IsUp() {
+		t.Error("check should be up")
+	}
+	healthy = false
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Error("check should be down")
+	}
+	if g, w := downErr, ErrUnhealthy; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+
+	ctxHc := NewHealthChecker(BoolRunnerCtxFunc(func(ctx context.Context) bool {
+		return ctx != nil
+	}), false, 1, 1)
+	ctxHc.IntervalRun()
+	if !ctxHc.IsUp() {
+		t.Error("check should be up")
+	}
+}
+
+func TestMinStateDuration(t *testing.T) {
+	var checkErr error
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), true, 1, 2)
+	hc.FastStart = false
+	hc.MinStateDuration = 20 * time.Millisecond
+
+	// counts met, but too early
+	checkErr = errors.New("error")
+	hc.IntervalRun()
+	hc.IntervalRun()
+	if !hc.IsUp() {
+		t.Error("check should be held up")
+	}
+
+	// held until time passes
+	time.Sleep(hc.MinStateDuration)
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Error("check should be down")
+	}
+
+	// time not met for going back up
+	checkErr = nil
+	hc.IntervalRun()
+	if hc.IsUp() {
+		t.Error("check should be held down")
+	}
+}
+
+func TestReport(t *testing.T) {
+	hc := NewHealthChecker(nil, false, 2, 2)
+	hc.FastStart = false
+	up := 0
+	hc.OnUp = func(numUps int, numDowns int) {
+		up++
+	}
+	observations := make(chan error, 3)
+	observations <- nil
+	observations <- nil
+	observations <- errors.New("error")
+	close(observations)
+	for err := range observations {
+		hc.Report(err)
+	}
+	if !hc.IsUp() {
+		t.Error("check should be up")
+	}
+	if g, w := up, 1; g != w {
+		t.Errorf("Incorrect OnUp calls, got=%v, want=%v", g, w)
+	}
+	if g, w := hc.Snapshot().Downs, 1; g != w {
+		t.Errorf("Incorrect downs, got=%v, want=%v", g, w)
+	}
+}
+
+func TestResetSameState(t *testing.T) {
+	hc := NewHealthChecker(nil, true, 1, 1)
+	ups := 0
+	hc.OnUp = func(numUps int, numDowns int) {
+		ups++
+	}
+	hc.Reset(true)
+	if ups != 0 {
+		t.Errorf("Reset to the same state should not call OnUp, got=%d", ups)
+	}
+	hc.ResetNotify(true)
+	if ups != 1 {
+		t.Errorf("ResetNotify should call OnUp, got=%d", ups)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var checkErr error
+	hc := NewHealthChecker(RunnerFunc(func() error {
+		return checkErr
+	}), false, 3, 2)
+	hc.FastStart = false
+
+	hc.IntervalRun()
+	hc.IntervalRun()
+	if g, w := hc.ProgressUp(), 2; g != w {
+		t.Errorf("Incorrect progress up, got=%v, want=%v", g, w)
+	}
+	if g, w := hc.ProgressDown(), 0; g != w {
+		t.Errorf("Incorrect progress down, got=%v, want=%v", g, w)
+	}
+	hc.IntervalRun()
+	if g, w := hc.ProgressUp(), 0; g != w {
+		t.Errorf("Incorrect progress up once up, got=%v, want=%v", g, w)
+	}
+
+	checkErr = errors.New("error")
+	hc.IntervalRun()
+	if g, w := hc.ProgressDown(), 1; g != w {
+		t.Errorf("Incorrect progress down, got=%v, want=%v", g, w)
+	}
+}
+
+func TestNewHealthCheckerFromRoutine(t *testing.T) {
+	failing := make(chan bool, 1)
+	failing <- false
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		f := <-failing
+		failing <- f
+		if f {
+			return errors.New("error")
+		}
+		return nil
+	}), time.Hour, 0)
+	hc := NewHealthCheckerFromRoutine(rt, 1, 1)
+	rt.Start()
+	defer rt.Stop()
+
+	waitState := func(want bool) {
+		timeout := time.After(time.Second)
+		for hc.IsUp() != want {
+			select {
+			case <-timeout:
+				t.Fatalf("Incorrect state, want=%v", want)
+			case <-time.Tick(time.Millisecond):
+			}
+		}
+	}
+	waitState(true)
+	<-failing
+	failing <- true
+	rt.TriggerRun()
+	waitState(false)
+}
+
+func TestAddOnUpDown(t *testing.T) {
+	hc := NewHealthChecker(nil, false, 1, 1)
+	var calls []string
+	hc.OnUp = func(numUps int, numDowns int) {
+		calls = append(calls, "field")
+	}
+	hc.AddOnUp(func(numUps int, numDowns int) {
+		calls = append(calls, "first")
+		panic("listener")
+	})
+	remove := hc.AddOnUp(func(numUps int, numDowns int) {
+		calls = append(calls, "second")
+	})
+	downs := 0
+	hc.AddOnDown(func(numUps int, numDowns int, lastErr error) {
+		downs++
+	})
+
+	hc.Report(nil)
+	if g, w := len(calls), 3; g != w {
+		t.Fatalf("Incorrect calls, got=%v, want=%v", calls, w)
+	}
+	if calls[0] != "field" || calls[1] != "first" || calls[2] != "second" {
+		t.Errorf("Incorrect order, got=%v", calls)
+	}
+
+	remove()
+	hc.Report(errors.New("error"))
+	hc.Report(nil)
+	if g, w := len(calls), 5; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", calls, w)
+	}
+	if g, w := downs, 1; g != w {
+		t.Errorf("Incorrect downs, got=%v, want=%v", g, w)
+	}
+}
+
+func TestLastTransitionWasFastStart(t *testing.T) {
+	hc := NewHealthChecker(nil, false, 2, 2)
+	var events []Event
+	hc.OnEvent = func(ev Event) {
+		events = append(events, ev)
+	}
+
+	hc.Report(nil)
+	if !hc.LastTransitionWasFastStart() || !hc.Snapshot().FastStartTransition {
+		t.Error("First transition should be fast start")
+	}
+	if g, w := events[len(events)-1], (Event{Type: EventUp, FastStart: true}); g.Type != w.Type || g.FastStart != w.FastStart {
+		t.Errorf("Incorrect event, got=%+v, want=%+v", g, w)
+	}
+
+	hc.Report(errors.New("error"))
+	hc.Report(errors.New("error"))
+	if hc.IsUp() || hc.LastTransitionWasFastStart() {
+		t.Error("Threshold transition should not be fast start")
+	}
+	if g := events[len(events)-1]; g.Type != EventDown || g.FastStart {
+		t.Errorf("Incorrect event, got=%+v", g)
+	}
+}
+
+func TestNewHealthCheckerFromRoutineRunAgain(t *testing.T) {
+	var runs int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		if atomic.AddInt32(&runs, 1) <= 3 {
+			return ErrRunAgain
+		}
+		return nil
+	}), time.Hour, 0)
+	hc := NewHealthCheckerFromRoutine(rt, 1, 1)
+	var downs int32
+	hc.OnDown = func(numUps int, numDowns int, lastErr error) {
+		atomic.AddInt32(&downs, 1)
+	}
+	rt.Start()
+	defer rt.StopAndWait(context.Background())
+
+	timeout := time.After(time.Second)
+	for atomic.LoadInt32(&runs) < 4 || !hc.IsUp() {
+		select {
+		case <-timeout:
+			t.Fatalf("Routine did not drain, runs=%v, up=%v", atomic.LoadInt32(&runs), hc.IsUp())
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+	if g, w := atomic.LoadInt32(&downs), int32(0); g != w {
+		t.Errorf("Incorrect downs, got=%v, want=%v", g, w)
+	}
+}
+
+func TestRestoreForcedState(t *testing.T) {
+	hc := NewHealthChecker(nil, true, 1, 1)
+	hc.ForceState(false)
+	data, err := hc.MarshalState()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	restored := NewHealthChecker(nil, false, 1, 1)
+	if err := restored.RestoreState(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored.IsUp() {
+		t.Error("Forced state should be restored")
+	}
+	restored.ClearForcedState()
+	if !restored.IsUp() {
+		t.Error("Computed state should be restored")
+	}
+}
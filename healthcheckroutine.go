@@ -0,0 +1,66 @@
+package goodroutine
+
+import "time"
+
+// HealthCheckRoutine implements a health check goroutine.
+// It combines a HealthChecker with the IntervalRoutine running it.
+// Fields present on both, like OnEvent, are set through the embedded HealthChecker or IntervalRoutine.
+type HealthCheckRoutine struct {
+	*HealthChecker
+	*IntervalRoutine
+}
+
+// NewHealthCheckRoutine creates a new HealthCheckRoutine, which takes care of running the check f() at interval.
+// Intervals are equivalent to IntervalRoutine, states and thresholds are equivalent to HealthChecker.
+// While the check is failing it is run at the retry interval without backoff, so that recovery is noticed promptly.
+// A typical usage is a runInterval of 10sec, retryInterval of 2sec, defaultState of false, thresholdUp of 3 and thresholdDown of 5.
+func NewHealthCheckRoutine(f Runner, runInterval time.Duration, retryInterval time.Duration, defaultState bool, thresholdUp int, thresholdDown int) *HealthCheckRoutine {
+	hc := NewHealthChecker(f, defaultState, thresholdUp, thresholdDown)
+	rt := NewIntervalRoutine(hc, runInterval, retryInterval)
+	rt.RetryBackoffDisabled = true
+	return &HealthCheckRoutine{
+		HealthChecker:   hc,
+		IntervalRoutine: rt,
+	}
+}
+
+// SetName sets the name of both the health check and its routine.
+func (hcr *HealthCheckRoutine) SetName(name string) {
+	hcr.HealthChecker.SetName(name)
+	hcr.IntervalRoutine.SetName(name)
+}
+
+// Name returns the name of the health check.
+func (hcr *HealthCheckRoutine) Name() string {
+	return hcr.HealthChecker.Name()
+}
+
+// LastErr returns the last error of the health check.
+func (hcr *HealthCheckRoutine) LastErr() error {
+	return hcr.HealthChecker.LastErr()
+}
+
+// Start the routine running the health check.
+// It returns true if this call started the routine, false if it was already started.
+// The routine uses ProbeJitter, unless its own Jitter is set.
+func (hcr *HealthCheckRoutine) Start() bool {
+	if hcr.IntervalRoutine.Jitter == 0 && hcr.ProbeJitter > 0 {
+		hcr.IntervalRoutine.Jitter = hcr.ProbeJitter
+	}
+	return hcr.IntervalRoutine.Start()
+}
+
+// Stop the routine running the health check.
+func (hcr *HealthCheckRoutine) Stop() {
+	hcr.IntervalRoutine.Stop()
+}
+
+// Done returns a channel that is closed once the routine has exited.
+func (hcr *HealthCheckRoutine) Done() <-chan struct{} {
+	return hcr.IntervalRoutine.Done()
+}
+
+// IsRunning returns true if the health check is currently running.
+func (hcr *HealthCheckRoutine) IsRunning() bool {
+	return hcr.IntervalRoutine.IsRunning()
+}
@@ -0,0 +1,108 @@
+package goodroutine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckRoutine(t *testing.T) {
+	healthy := make(chan bool, 1)
+	healthy <- true
+	f := func() error {
+		select {
+		case h := <-healthy:
+			if !h {
+				return errors.New("error")
+			}
+		default:
+		}
+		return nil
+	}
+
+	up := make(chan bool, 1)
+	hcr := NewHealthCheckRoutine(RunnerFunc(f), time.Hour, 0, false, 1, 1)
+	hcr.OnUp = func(numUps int, numDowns int) {
+		up <- true
+	}
+	hcr.OnDown = func(numUps int, numDowns int, lastErr error) {
+		up <- false
+	}
+	hcr.Start()
+	defer hcr.Stop()
+
+	select {
+	case u := <-up:
+		if !u || !hcr.IsUp() {
+			t.Error("check should be up")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("check did not go up")
+	}
+
+	healthy <- false
+	hcr.TriggerRun()
+	select {
+	case u := <-up:
+		if u || hcr.IsUp() {
+			t.Error("check should be down")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("check did not go down")
+	}
+}
+
+func TestHealthCheckRoutineName(t *testing.T) {
+	hcr := NewHealthCheckRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0, false, 1, 1)
+	if g, w := hcr.Name(), ""; g != w {
+		t.Errorf("Name does not match, got=%v, want=%v", g, w)
+	}
+	hcr.SetName("db")
+	if g, w := hcr.HealthChecker.Name(), "db"; g != w {
+		t.Errorf("Name does not match, got=%v, want=%v", g, w)
+	}
+	if g, w := hcr.IntervalRoutine.Name(), "db"; g != w {
+		t.Errorf("Name does not match, got=%v, want=%v", g, w)
+	}
+}
+
+func TestHealthCheckRoutineProbeJitter(t *testing.T) {
+	hcr := NewHealthCheckRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0, false, 1, 1)
+	hcr.ProbeJitter = 0.2
+	hcr.Start()
+	defer hcr.Stop()
+	if g, w := hcr.IntervalRoutine.Jitter, 0.2; g != w {
+		t.Errorf("Incorrect jitter, got=%v, want=%v", g, w)
+	}
+}
+
+func TestHealthCheckRoutineStopDuringCheck(t *testing.T) {
+	started := make(chan bool, 1)
+	hcr := NewHealthCheckRoutine(RunnerCtxFunc(func(ctx context.Context) error {
+		started <- true
+		<-ctx.Done()
+		return ctx.Err()
+	}), time.Hour, 0, true, 1, 1)
+	hcr.CheckTimeout = time.Hour
+	down := make(chan bool, 1)
+	hcr.OnDown = func(numUps int, numDowns int, lastErr error) {
+		down <- true
+	}
+	hcr.Start()
+	<-started
+	hcr.StopAndWait(context.Background())
+
+	select {
+	case <-down:
+		t.Error("Stop during a check should not fail it")
+	default:
+	}
+	if !hcr.IsUp() {
+		t.Error("check should still be up")
+	}
+}
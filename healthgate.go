@@ -0,0 +1,22 @@
+package goodroutine
+
+import "net/http"
+
+// HealthGate returns an http middleware responding 503 Service Unavailable while hc is down,
+// instead of calling the next handler. Requests to the allowed paths always go through, e.g. "/healthz".
+// To gate on several checkers, chain the middlewares, e.g. HealthGate(db)(HealthGate(cache)(handler)).
+func HealthGate(hc *HealthChecker, allowedPaths ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(allowedPaths))
+	for _, path := range allowedPaths {
+		allowed[path] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hc.IsUp() && !allowed[r.URL.Path] {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
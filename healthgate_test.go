@@ -0,0 +1,31 @@
+package goodroutine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthGate(t *testing.T) {
+	hc := NewHealthChecker(nil, true, 1, 1)
+	handler := HealthGate(hc, "/healthz")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, c := range []struct {
+		up     bool
+		path   string
+		status int
+	}{
+		{true, "/api", http.StatusOK},
+		{false, "/api", http.StatusServiceUnavailable},
+		{false, "/healthz", http.StatusOK},
+	} {
+		hc.ResetQuiet(c.up)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", c.path, nil))
+		if g, w := rec.Code, c.status; g != w {
+			t.Errorf("%v up=%v: incorrect status, got=%v, want=%v", c.path, c.up, g, w)
+		}
+	}
+}
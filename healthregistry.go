@@ -0,0 +1,106 @@
+package goodroutine
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HealthRegistry is a set of named health checks, served over HTTP.
+type HealthRegistry struct {
+	mu     sync.RWMutex
+	checks map[string]*HealthChecker
+}
+
+// HealthReport is the status of a set of health checks, as served by HealthRegistry.
+type HealthReport struct {
+	// Up is true if all checks are up and none is missing
+	Up     bool                      `json:"up"`
+	Checks map[string]HealthSnapshot `json:"checks"`
+	// Missing lists the requested names that are not registered
+	Missing []string `json:"missing,omitempty"`
+}
+
+// NewHealthRegistry creates a new empty HealthRegistry.
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{
+		checks: make(map[string]*HealthChecker),
+	}
+}
+
+// Register adds a health check under the given name, replacing any check with the same name.
+func (hr *HealthRegistry) Register(name string, hc *HealthChecker) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.checks[name] = hc
+}
+
+// Unregister removes the health check with the given name.
+func (hr *HealthRegistry) Unregister(name string) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	delete(hr.checks, name)
+}
+
+// Report returns the status of the given checks, or of all checks if no name is given.
+// Unknown names are listed in Missing and make the report down.
+func (hr *HealthRegistry) Report(names ...string) HealthReport {
+	var missing []string
+	hr.mu.RLock()
+	checks := make(map[string]*HealthChecker, len(hr.checks))
+	if len(names) == 0 {
+		for name, hc := range hr.checks {
+			checks[name] = hc
+		}
+	} else {
+		for _, name := range names {
+			if hc, ok := hr.checks[name]; ok {
+				checks[name] = hc
+			} else {
+				missing = append(missing, name)
+			}
+		}
+	}
+	hr.mu.RUnlock()
+
+	report := HealthReport{
+		Up:      len(missing) == 0,
+		Checks:  make(map[string]HealthSnapshot, len(checks)),
+		Missing: missing,
+	}
+	for name, hc := range checks {
+		snap := hc.Snapshot()
+		report.Checks[name] = snap
+		report.Up = report.Up && snap.Up
+	}
+	return report
+}
+
+// ServeHTTP implements http.Handler, it writes the HealthReport as JSON, with status 503 if any check is down.
+// The "only" query parameter restricts the report to a comma separated list of checks, e.g. ?only=db,cache
+// If any of the listed checks is not registered, the report is down with status 404 and lists it in Missing,
+// so a typo does not pass as healthy.
+func (hr *HealthRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if only := r.URL.Query().Get("only"); only != "" {
+		for _, name := range strings.Split(only, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	report := hr.Report(names...)
+	status := http.StatusOK
+	if len(report.Missing) > 0 {
+		status = http.StatusNotFound
+	} else if !report.Up {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	json.NewEncoder(w).Encode(report)
+}
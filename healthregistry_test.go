@@ -0,0 +1,59 @@
+package goodroutine
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	db := NewHealthChecker(RunnerFunc(func() error {
+		return nil
+	}), false, 1, 1)
+	db.IntervalRun()
+	cache := NewHealthChecker(RunnerFunc(func() error {
+		return errors.New("unreachable")
+	}), true, 1, 1)
+	cache.IntervalRun()
+
+	hr := NewHealthRegistry()
+	hr.Register("db", db)
+	hr.Register("cache", cache)
+
+	for _, c := range []struct {
+		url    string
+		status int
+		checks int
+	}{
+		{"/healthz", http.StatusServiceUnavailable, 2},
+		{"/healthz?only=db", http.StatusOK, 1},
+		{"/healthz?only=db,cache", http.StatusServiceUnavailable, 2},
+		{"/healthz?only=db,dbb", http.StatusNotFound, 1},
+		{"/healthz?only=unknown", http.StatusNotFound, 0},
+	} {
+		rec := httptest.NewRecorder()
+		hr.ServeHTTP(rec, httptest.NewRequest("GET", c.url, nil))
+		if g, w := rec.Code, c.status; g != w {
+			t.Errorf("%v: incorrect status, got=%v, want=%v", c.url, g, w)
+		}
+		var report HealthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%v: invalid json: %v", c.url, err)
+		}
+		if g, w := len(report.Checks), c.checks; g != w {
+			t.Errorf("%v: incorrect checks, got=%v, want=%v", c.url, g, w)
+		}
+		if g, w := report.Up, c.status == http.StatusOK; g != w {
+			t.Errorf("%v: incorrect up, got=%v, want=%v", c.url, g, w)
+		}
+	}
+	if g, w := hr.Report("db", "dbb", "cahce").Missing, []string{"dbb", "cahce"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("Incorrect missing checks, got=%v, want=%v", g, w)
+	}
+	if g, w := hr.Report("cache").Checks["cache"].LastError, "unreachable"; g != w {
+		t.Errorf("Incorrect last error, got=%v, want=%v", g, w)
+	}
+}
@@ -6,21 +6,77 @@
 // Features include:
 // - interval-based goroutine that safely runs a function
 // - threshold based up / down healthcheck
-//
 package goodroutine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrRunAgain may be returned by a Runner that made partial progress and wants to be run again right away,
+// e.g. to drain a queue in batches. The run is considered successful, the next run happens after a minimal
+// delay instead of the run interval. It can be wrapped.
+var ErrRunAgain = errors.New("run again")
+
+// ErrAlreadyStarted is returned by Run if the routine was already started.
+var ErrAlreadyStarted = errors.New("routine already started")
+
+// runAgainDelay is the delay before running again on ErrRunAgain, it avoids a hot loop.
+const runAgainDelay = time.Millisecond
+
+// DefaultMinInterval is the minimum wait between runs of routines that do not set MinInterval.
+// Set it to 0 to disable the floor for all routines.
+var DefaultMinInterval = time.Millisecond
+
+// PanicError is the error reported in place of a recovered panic, e.g. in LastErr or Results.
+// It counts as a failed run for retries, and as a failed check for HealthChecker.
+// Use errors.As to detect it and get the original value,
+// a value that is an error is also matched by errors.Is and errors.As through Unwrap.
+type PanicError struct {
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error, nil otherwise.
+func (pe *PanicError) Unwrap() error {
+	err, _ := pe.Value.(error)
+	return err
+}
+
+// recoveredPanic returns the PanicError for a recovered value, with the current stack,
+// or the value itself if it is a PanicError propagated as is, e.g. by HealthChecker, to keep its original stack.
+func recoveredPanic(r interface{}) *PanicError {
+	if pe, ok := r.(*PanicError); ok {
+		return pe
+	}
+	return &PanicError{Value: r, Stack: debug.Stack()}
+}
+
 // Runner implements a function that is run at interval
 type Runner interface {
 	IntervalRun() error
 }
 
+// RunnerCtx implements a function that is run at interval, with a context bounding the run.
+// It is an optional extension of Runner, used when available.
+type RunnerCtx interface {
+	IntervalRunCtx(ctx context.Context) error
+}
+
 // The RunnerFunc type is an adapter to allow the use of
 // ordinary functions as Runner. If f is a function
 // with the appropriate signature, RunnerFunc(f) is a
@@ -32,49 +88,256 @@ func (rf RunnerFunc) IntervalRun() error {
 	return rf()
 }
 
+// The RunnerCtxFunc type is an adapter to allow the use of
+// ordinary functions taking a context as Runner and RunnerCtx.
+// The context is honored by IntervalRoutine, which cancels it on Stop,
+// and by HealthChecker, which passes its own context bounded by CheckTimeout.
+type RunnerCtxFunc func(ctx context.Context) error
+
+// IntervalRun implements the Runner interface, using a background context
+func (rf RunnerCtxFunc) IntervalRun() error {
+	return rf(context.Background())
+}
+
+// IntervalRunCtx implements the RunnerCtx interface
+func (rf RunnerCtxFunc) IntervalRunCtx(ctx context.Context) error {
+	return rf(ctx)
+}
+
 // IntervalRoutine implements a management goroutine.
 // It provides a safe way to run a function, at interval, from a single goroutine.
+// Runs never overlap: a run that takes longer than the interval delays the next one, see SkippedRunCount.
 type IntervalRoutine struct {
-	runner          Runner
-	runInterval     time.Duration
-	retryInterval   time.Duration
-	currentInterval time.Duration
-	force           chan bool
-	done            chan bool
-	start           sync.Once
-	stop            sync.Once
+	mu                sync.RWMutex
+	name              string
+	runner            Runner
+	runInterval       time.Duration
+	retryInterval     time.Duration
+	currentInterval   time.Duration
+	nextRun           time.Time
+	lastRun           time.Time
+	lastErr           error
+	skipped           int64
+	runs              int64
+	errorsTotal       int64
+	lastDuration      time.Duration
+	results           chan RunResult
+	lastPanic         *PanicError
+	finalCtx          context.Context
+	finalErr          error
+	lastPanicTime     time.Time
+	stopTimer         *time.Timer
+	resultsClosed     bool
+	schedules         []*schedule
+	consecutivePanics int
+	consecutiveErrors int
+	consecutiveOK     int
+	backoff           time.Duration
+	failingSince      time.Time
+	boostInterval     time.Duration
+	boostUntil        time.Time
+	armedAt           time.Time
+	armedWait         time.Duration
+	clockSkews        int64
+	skippedNonLeader  int64
+	clock             func() time.Time
+	running           int32
+	started           int32
+	stopAfterNext     int32
+	panicStopped      int32
+	dispatch          func()
+	completed         chan runOutcome
+	workers           sync.WaitGroup
+	force             chan bool
+	done              chan bool
+	exited            chan struct{}
+	firstRun          chan struct{}
+	firstRunOnce      sync.Once
+	succeeded         chan struct{}
+	succeededOnce     sync.Once
+	ctx               context.Context
+	cancel            context.CancelFunc
+	start             sync.Once
+	stop              sync.Once
 
 	// PanicRecoverDisabled if set to true, panics are not recovered
 	PanicRecoverDisabled bool
 	// RetryBackoffDisabled if set to true, retry interval does not increase exponentially
 	RetryBackoffDisabled bool
 	OnPanic              func(recovered interface{})
+	// OnPanicStack is called on a recovered panic with the stack captured at recovery, before OnPanic.
+	// If either hook is set, the default printing of the panic is disabled.
+	OnPanicStack func(recovered interface{}, stack []byte)
+	// OnPanicDecide if set, is called on a recovered panic in place of OnPanic and OnPanicStack,
+	// returning false stops the routine as if Stop was called, e.g. on a fatal panic value.
+	OnPanicDecide func(recovered interface{}, stack []byte) bool
+	// OnLoopIdle is a testing aid, called by the routine goroutine right before it waits for the next run.
+	// Once called, the routine is parked until its timer, a trigger or Stop, which allows deterministic tests.
+	OnLoopIdle func()
+	// OnEvent is called after every run, with EventRunOK, EventRunError or EventPanic
+	OnEvent func(ev Event)
+	// MaxRetryInterval if set, caps the retry interval backoff instead of the run interval,
+	// e.g. to retry within minutes a function that normally runs daily. It may be higher than the run interval.
+	MaxRetryInterval time.Duration
+	// InitialDelay if set, the first run happens after that delay instead of right at Start.
+	// A TriggerRun during the delay runs right away, and the normal interval applies from then on.
+	InitialDelay time.Duration
+	// InitialDelayMax if set higher than InitialDelay, the initial delay is random between InitialDelay and InitialDelayMax,
+	// e.g. to spread the first runs across a fleet
+	InitialDelayMax time.Duration
+	// PanicAsErrorDisabled if set to true, a recovered panic does not count as an error, the current interval is kept
+	PanicAsErrorDisabled bool
+	// MaxConsecutivePanics if set, the routine stops itself after that many consecutive panics, 0 means unlimited
+	MaxConsecutivePanics int
+	// OnPanicLimit is called when the routine stops after MaxConsecutivePanics
+	OnPanicLimit func(numPanics int)
+	// ShouldRetryFast if set, decides whether an error is retried at the retry interval with backoff.
+	// Errors for which it returns false wait for the normal run interval and reset the backoff,
+	// e.g. for an expected "busy" error. By default all errors are retried fast.
+	ShouldRetryFast func(err error) bool
+	// Jitter if set, randomizes each wait by up to that fraction in either direction, from 0.0 to 1.0,
+	// e.g. 0.1 waits between 9 and 11min for a 10min interval, to spread runs across a fleet
+	Jitter float64
+	// RunOnStop if set to true, Stop causes one final run right away before the routine exits,
+	// e.g. to flush buffered data. The run is given a fresh context, since the routine context is cancelled on Stop.
+	// StopAndWait waits for that final run. It does not apply when the routine stops by itself.
+	RunOnStop bool
+	// OnSchedule is a debugging aid, called by the routine goroutine for each scheduling decision:
+	// when a run starts, with ScheduleTimer or ScheduleForce and the current interval,
+	// and when the next run is scheduled, with ScheduleNormal, ScheduleRetryBackoff or ScheduleForce and its interval.
+	OnSchedule func(reason ScheduleReason, nextInterval time.Duration)
+	// RunOnceMode if set to true, the routine stops itself after the first successful run, see NewOneShotRoutine
+	RunOnceMode bool
+	// MaxRetries if set, the routine stops itself once a run failed after that many consecutive retries,
+	// then calls OnGiveUp with the last error
+	MaxRetries int
+	// MaxTotalRetryDuration if set, the routine stops itself once runs have been failing for that long,
+	// since the first failure after a success, then calls OnGiveUp with the last error
+	MaxTotalRetryDuration time.Duration
+	// OnGiveUp is called when the routine stops after MaxRetries or MaxTotalRetryDuration
+	OnGiveUp func(err error)
+	// NextInterval if set, is called after each run to get the run interval, overriding runInterval,
+	// e.g. to run more often during business hours. Retry and backoff still apply on errors.
+	NextInterval func(now time.Time) time.Duration
+	// SuccessesToResetBackoff if set, is the number of consecutive successful runs needed to reset the retry backoff.
+	// By default a single success resets it, so that the next error is retried at the retry interval.
+	// With a higher value, an error after fewer successes continues the backoff where it was, e.g. during flapping.
+	SuccessesToResetBackoff int
+	// MinRunInterval if set, is the minimum time between the end of a run and the start of the next,
+	// delaying triggered runs and retries, e.g. to protect an expensive function from a burst of triggers.
+	// Unlike runInterval, which is the cadence when idle, it only limits the rate of runs.
+	MinRunInterval time.Duration
+	// OnRecovered is called when a run succeeds after failed runs, once the backoff is reset,
+	// with the number of consecutive failures, e.g. to log a recovery.
+	// Failures that are not retried fast, see ShouldRetryFast, do not count.
+	OnRecovered func(afterErrors int)
+	// SlowRunThreshold if set, OnSlowRun is called with the duration of runs lasting longer, once they return.
+	// It is only diagnostic, the run is not interrupted, e.g. to notice a degrading function.
+	SlowRunThreshold time.Duration
+	OnSlowRun        func(d time.Duration)
+	// OnClockSkew is called when the timer fires later than armed by more than ClockSkewTolerance (1 second by default),
+	// with the armed wait and the wall time actually elapsed, e.g. after a VM was suspended.
+	// It is only observational, see also ClockSkewCount.
+	OnClockSkew        func(expected, actual time.Duration)
+	ClockSkewTolerance time.Duration
+	// ShouldRun if set, is polled before each run, timer or trigger, e.g. "am I the leader?" for cluster-wide maintenance.
+	// When it returns false the function is not called, the run is counted in SkippedAsNonLeader
+	// and the next run is armed at the run interval, without affecting retries or backoff.
+	ShouldRun func() bool
+	// MinInterval is a floor applied to every wait before a timed run, including retries, backoff and ErrRunAgain,
+	// so that a tiny interval and a function failing fast can never busy-spin.
+	// If 0, DefaultMinInterval is used, a negative value disables the floor.
+	// A 0 interval still means runs on trigger only, triggered runs are not delayed, see MinRunInterval.
+	MinInterval time.Duration
 }
 
 // NewIntervalRoutine creates a new IntervalRoutine.
+// If runner implements RunnerCtx, it is given a context that is cancelled on Stop.
 // Runs may be triggered in 3 ways:
 // - normally at each run interval
 // - at the retry interval, if the last run returned an error
 // - if TriggerRun was called
 // A typical usage is a runInterval of 5min, retryInterval of 30sec.
-// By default the retry interval increases exponentially from retryInterval up to runInterval.
-// retryInterval cannot be set higher than runInterval.
+// By default the retry interval increases exponentially from retryInterval up to runInterval, see MaxRetryInterval.
+// A retryInterval higher than the maximum retry interval is ignored, errors are then retried at runInterval.
 func NewIntervalRoutine(runner Runner, runInterval time.Duration, retryInterval time.Duration) *IntervalRoutine {
-	if retryInterval > runInterval {
-		// wrong interval, disable custom retry
-		retryInterval = 0
+	rrt := &IntervalRoutine{}
+	rrt.init(runner, runInterval, retryInterval)
+	return rrt
+}
+
+// NewOneShotRoutine creates a new IntervalRoutine that runs until it succeeds once, then stops itself, see RunOnceMode.
+// Errors are retried from retryInterval with backoff, up to 64 times retryInterval, e.g. for a bootstrap task.
+// A non-positive retryInterval is replaced by 1 second, so that a failed run is always retried.
+func NewOneShotRoutine(runner Runner, retryInterval time.Duration) *IntervalRoutine {
+	if retryInterval <= 0 {
+		retryInterval = time.Second
+	}
+	rrt := NewIntervalRoutine(runner, 0, retryInterval)
+	rrt.RunOnceMode = true
+	rrt.MaxRetryInterval = 64 * retryInterval
+	return rrt
+}
+
+// NewIntervalRoutineFromStrings creates a new IntervalRoutine like NewIntervalRoutine,
+// with intervals parsed from strings like "5m" or "30s", e.g. from a config, an empty string meaning 0.
+// It returns an error naming the invalid field if an interval does not parse, is negative,
+// or if the retry interval is higher than the run interval.
+func NewIntervalRoutineFromStrings(runner Runner, runInterval string, retryInterval string) (*IntervalRoutine, error) {
+	run, err := parseInterval(runInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run interval: %w", err)
+	}
+	retry, err := parseInterval(retryInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry interval: %w", err)
+	}
+	if retry > run {
+		return nil, fmt.Errorf("invalid retry interval: %v is higher than run interval %v", retry, run)
+	}
+	return NewIntervalRoutine(runner, run, retry), nil
+}
+
+func parseInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
 	}
-	return &IntervalRoutine{
-		runner:        runner,
-		runInterval:   runInterval,
-		retryInterval: retryInterval,
-		force:         make(chan bool, 1),
-		done:          make(chan bool, 1),
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
 	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %v", d)
+	}
+	return d, nil
+}
+
+// init sets up the routine, it is used by types embedding an IntervalRoutine.
+func (rrt *IntervalRoutine) init(runner Runner, runInterval time.Duration, retryInterval time.Duration) {
+	rrt.runner = runner
+	rrt.runInterval = runInterval
+	rrt.retryInterval = retryInterval
+	rrt.force = make(chan bool, 1)
+	rrt.done = make(chan bool, 1)
+	rrt.exited = make(chan struct{})
+	rrt.firstRun = make(chan struct{})
+	rrt.succeeded = make(chan struct{})
+	rrt.ctx, rrt.cancel = context.WithCancel(context.Background())
+	rrt.clock = time.Now
+}
+
+// now returns the current time of the routine clock, replaced in tests.
+func (rrt *IntervalRoutine) now() time.Time {
+	if rrt.clock == nil {
+		return time.Now()
+	}
+	return rrt.clock()
 }
 
 // TriggerRun triggers a run as soon as possible.
 // Does nothing if a forced run is already scheduled.
+// Triggers are coalesced: all triggers received before a run starts are served by that run,
+// and any number of triggers received during a run cause exactly one subsequent run.
 func (rrt *IntervalRoutine) TriggerRun() {
 	select {
 	case rrt.force <- true:
@@ -83,83 +346,855 @@ func (rrt *IntervalRoutine) TriggerRun() {
 	}
 }
 
+// DrainTriggers discards a pending trigger, if any, without blocking.
+// It is safe to call concurrently with TriggerRun, a trigger arriving after it still schedules a run.
+func (rrt *IntervalRoutine) DrainTriggers() {
+	select {
+	case <-rrt.force:
+	default:
+	}
+}
+
+// TriggerOnSignal triggers a run each time one of the given signals is received, e.g. syscall.SIGHUP to reload.
+// Several routines may subscribe to the same signal, each one gets triggered.
+// The returned function unregisters the signals, this is also done automatically when the routine stops.
+func (rrt *IntervalRoutine) TriggerOnSignal(sig ...os.Signal) func() {
+	c := make(chan os.Signal, 1)
+	quit := make(chan bool)
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(quit)
+		})
+	}
+
+	signal.Notify(c, sig...)
+	go func() {
+		for {
+			select {
+			case <-c:
+				rrt.TriggerRun()
+			case <-rrt.done:
+				stop()
+				return
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
 // Start the management routine.
-func (rrt *IntervalRoutine) Start() {
+// It returns true if this call started the routine, false if it was already started or stopped.
+func (rrt *IntervalRoutine) Start() bool {
+	started := false
 	rrt.start.Do(func() {
+		started = true
+		atomic.StoreInt32(&rrt.started, 1)
+		untrack := rrt.track()
 		go func() {
-			// add a force to run once at startup, ticker will get set after
-			rrt.force <- true
+			defer close(rrt.exited)
+			defer untrack()
+			defer rrt.closeResults()
+			rrt.startSchedules()
+			if delay := rrt.initialDelay(); delay > 0 {
+				rrt.setNextRun(0, delay)
+			} else {
+				// add a force to run once at startup, ticker will get set after,
+				// never blocks since the loop is not running yet, a trigger may already be pending
+				rrt.TriggerRun()
+			}
 			for {
 				if !rrt.runSafe() {
 					break
 				}
 			}
+			rrt.waitWorkers()
 		}()
 	})
+	return started
+}
+
+// StartContext starts the management routine like Start, and stops it when ctx is done.
+// It returns true if this call started the routine, false if it was already started.
+func (rrt *IntervalRoutine) StartContext(ctx context.Context) bool {
+	if !rrt.Start() {
+		return false
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			rrt.Stop()
+		case <-rrt.exited:
+		}
+	}()
+	return true
+}
+
+// SetName sets the name of the routine, used in logs to tell routines apart.
+func (rrt *IntervalRoutine) SetName(name string) {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.name = name
+}
+
+// Name returns the name of the routine, empty by default.
+func (rrt *IntervalRoutine) Name() string {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.name
+}
+
+// initialDelay returns the delay before the first run.
+func (rrt *IntervalRoutine) initialDelay() time.Duration {
+	delay := rrt.InitialDelay
+	if spread := rrt.InitialDelayMax - delay; spread > 0 {
+		delay += time.Duration(rand.Int63n(int64(spread)))
+	}
+	return delay
+}
+
+// CurrentInterval returns the interval until the next run, as last scheduled.
+// It is the run interval normally, or the retry interval with backoff after errors.
+// It is 0 before the first run, or if runs only happen on trigger.
+func (rrt *IntervalRoutine) CurrentInterval() time.Duration {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.currentInterval
+}
+
+// SetRetryInterval changes the retry interval, it applies from the next failed run.
+// An ongoing backoff continues from its current interval, use ResetBackoff to restart it from the new base.
+func (rrt *IntervalRoutine) SetRetryInterval(retryInterval time.Duration) {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.retryInterval = retryInterval
+}
+
+// ResetBackoff clears any accumulated retry backoff, the current interval goes back to the run interval
+// and the next failed run retries at the retry interval again.
+// The ongoing wait is not shortened, use TriggerRun to run right away.
+func (rrt *IntervalRoutine) ResetBackoff() {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.consecutiveErrors = 0
+	rrt.backoff = 0
+	rrt.currentInterval = rrt.runInterval
+}
+
+// Boost temporarily runs the routine at interval instead of the run interval, until the window d has elapsed.
+// The boost applies to runs scheduled before the window ends, so the last boosted wait may end slightly after it,
+// the first run scheduled after the window reverts to the run interval.
+// Failed runs still back off as usual, up to the normal maximum.
+// A second Boost replaces the interval and restarts the window.
+// The ongoing wait is not shortened, use TriggerRun to run right away.
+func (rrt *IntervalRoutine) Boost(interval time.Duration, d time.Duration) {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.boostInterval = interval
+	rrt.boostUntil = rrt.now().Add(d)
+}
+
+// LastRunTime returns the time the last run completed, zero if none.
+func (rrt *IntervalRoutine) LastRunTime() time.Time {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.lastRun
+}
+
+// LastErr returns the error of the last run.
+func (rrt *IntervalRoutine) LastErr() error {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.lastErr
+}
+
+// LastPanic returns the value, stack and time of the last recovered panic, or a nil value if none.
+// It is kept until overwritten by another panic, or cleared with ClearLastPanic.
+func (rrt *IntervalRoutine) LastPanic() (value interface{}, stack []byte, when time.Time) {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	if rrt.lastPanic == nil {
+		return nil, nil, time.Time{}
+	}
+	return rrt.lastPanic.Value, rrt.lastPanic.Stack, rrt.lastPanicTime
+}
+
+// ClearLastPanic clears the last recovered panic.
+func (rrt *IntervalRoutine) ClearLastPanic() {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.lastPanic = nil
+	rrt.lastPanicTime = time.Time{}
+}
+
+// SkippedRunCount returns the number of run intervals that elapsed entirely during a longer run.
+// Runs are strictly serialized, so those runs are skipped rather than queued, a growing count means runs fall behind.
+func (rrt *IntervalRoutine) SkippedRunCount() int64 {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.skipped
+}
+
+// SkippedAsNonLeader returns the number of runs skipped because ShouldRun returned false.
+func (rrt *IntervalRoutine) SkippedAsNonLeader() int64 {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.skippedNonLeader
+}
+
+// ClockSkewCount returns the number of times the timer fired late by more than ClockSkewTolerance, see OnClockSkew.
+func (rrt *IntervalRoutine) ClockSkewCount() int64 {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.clockSkews
+}
+
+// StopAfterNextRun stops the routine after exactly one more run, e.g. to make a final attempt at flushing data.
+// The run happens when next scheduled, which may be after the current retry backoff, or on TriggerRun.
+// A run in progress when it is called does not count.
+func (rrt *IntervalRoutine) StopAfterNextRun() {
+	atomic.StoreInt32(&rrt.stopAfterNext, 1)
+}
+
+// WaitForFirstRun blocks until the first run has completed, successfully or not, or ctx is done.
+// It returns nil once the first run completed, ctx error otherwise.
+func (rrt *IntervalRoutine) WaitForFirstRun(ctx context.Context) error {
+	select {
+	case <-rrt.firstRun:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// firstSuccess returns a channel closed after the first successful run, see RoutineGroup.WaitForDeps.
+func (rrt *IntervalRoutine) firstSuccess() <-chan struct{} {
+	return rrt.succeeded
+}
+
+// finalError returns the error of the final run on Stop, see RoutineGroup.StopAllReverseAndWait.
+func (rrt *IntervalRoutine) finalError() error {
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.finalErr
+}
+
+// Stopped returns true if Stop was called.
+func (rrt *IntervalRoutine) Stopped() bool {
+	select {
+	case <-rrt.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel that is closed once the management goroutine has exited.
+// Unlike Stop which only requests the routine to stop, it allows to wait for an in-flight run to finish.
+func (rrt *IntervalRoutine) Done() <-chan struct{} {
+	return rrt.exited
 }
 
 // Stop the management routine.
+// If called before Start, the routine never runs: Start becomes a no-op and Done is closed right away.
 func (rrt *IntervalRoutine) Stop() {
 	rrt.stop.Do(func() {
 		close(rrt.done)
+		rrt.cancel()
+	})
+	rrt.start.Do(func() {
+		// never started
+		rrt.closeResults()
+		close(rrt.exited)
 	})
 }
 
-func (rrt *IntervalRoutine) runSafe() bool {
+// StopAt arms a timer to Stop the routine at the given time, e.g. to end a backfill before peak hours.
+// It replaces any previous StopAt, a time in the past stops the routine right away.
+// A manual Stop before that time is not affected, the routine is stopped once either way.
+func (rrt *IntervalRoutine) StopAt(t time.Time) {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	if rrt.stopTimer != nil {
+		rrt.stopTimer.Stop()
+	}
+	rrt.stopTimer = time.AfterFunc(time.Until(t), rrt.Stop)
+}
+
+// CancelStopAt cancels the stop armed by StopAt, if it did not fire yet.
+func (rrt *IntervalRoutine) CancelStopAt() {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	if rrt.stopTimer != nil {
+		rrt.stopTimer.Stop()
+		rrt.stopTimer = nil
+	}
+}
+
+// StopAndWait stops the routine and waits for its goroutine to exit, including any run in progress.
+// It returns ctx.Err() if ctx is done before the routine exits.
+func (rrt *IntervalRoutine) StopAndWait(ctx context.Context) error {
+	rrt.Stop()
+	select {
+	case <-rrt.exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopAndRunFinal stops the routine with a final run like RunOnStop, waits for it and returns its error,
+// e.g. to know whether a shutdown flush succeeded. The final run is given ctx.
+// It returns ctx.Err() if ctx is done before the routine exits,
+// and nil without any final run if the routine was not running.
+func (rrt *IntervalRoutine) StopAndRunFinal(ctx context.Context) error {
+	rrt.mu.Lock()
+	rrt.finalCtx = ctx
+	rrt.mu.Unlock()
+	if err := rrt.StopAndWait(ctx); err != nil {
+		return err
+	}
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return rrt.finalErr
+}
+
+// Run starts the routine and blocks until ctx is done, then stops it and waits for any run in progress.
+// It returns ctx.Err(), or nil if the routine stopped by itself, e.g. after StopAfterNextRun.
+// It is typically used in main() with signal.NotifyContext.
+// Run is an alternative to Start, it returns ErrAlreadyStarted if the routine was already started or stopped.
+func (rrt *IntervalRoutine) Run(ctx context.Context) error {
+	if !rrt.Start() {
+		return ErrAlreadyStarted
+	}
+	select {
+	case <-rrt.exited:
+		return nil
+	case <-ctx.Done():
+	}
+	rrt.StopAndWait(context.Background())
+	return ctx.Err()
+}
+
+// RoutineState is the lifecycle state of a routine, see IntervalRoutine.State.
+// There is no paused state, a routine cannot be paused.
+type RoutineState int
+
+const (
+	// StateCreated is a routine not started yet
+	StateCreated RoutineState = iota
+	// StateRunning is a started routine, whether a run is in progress or not, see IsRunning
+	StateRunning
+	// StateStopping is a stopped routine whose goroutine has not exited yet, e.g. finishing a run
+	StateStopping
+	// StateStopped is a routine whose goroutine has exited, or stopped before being started
+	StateStopped
+)
+
+func (rs RoutineState) String() string {
+	switch rs {
+	case StateCreated:
+		return "created"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// State returns the lifecycle state of the routine.
+// The valid transitions are Created -> Running -> Stopping -> Stopped, and Created -> Stopped on Stop before Start.
+func (rrt *IntervalRoutine) State() RoutineState {
+	// checked in reverse order of the transitions, so that a concurrent transition is never missed
+	select {
+	case <-rrt.exited:
+		return StateStopped
+	default:
+	}
+	if rrt.Stopped() {
+		return StateStopping
+	}
+	if atomic.LoadInt32(&rrt.started) == 1 {
+		return StateRunning
+	}
+	return StateCreated
+}
+
+// IsRunning returns true while the function is being run.
+func (rrt *IntervalRoutine) IsRunning() bool {
+	return atomic.LoadInt32(&rrt.running) == 1
+}
+
+// run runs a function once, recovering any panic unless disabled.
+func (rrt *IntervalRoutine) run(runner Runner) (panicked bool, err error) {
+	return rrt.runCtx(rrt.ctx, runner)
+}
+
+// runCtx runs the runner, giving ctx to a RunnerCtx.
+func (rrt *IntervalRoutine) runCtx(ctx context.Context, runner Runner) (panicked bool, err error) {
+	atomic.StoreInt32(&rrt.running, 1)
+	// clear even on panic
+	defer atomic.StoreInt32(&rrt.running, 0)
 	if !rrt.PanicRecoverDisabled {
 		// recover any panic
 		defer func() {
 			if r := recover(); r != nil {
+				panicked = true
+				pe := recoveredPanic(r)
+				r, stack := pe.Value, pe.Stack
+				err = pe
+				rrt.mu.Lock()
+				rrt.lastPanic = pe
+				rrt.lastPanicTime = time.Now()
+				rrt.mu.Unlock()
+				if rrt.OnPanicDecide != nil {
+					if !rrt.OnPanicDecide(r, stack) {
+						// stops by itself, without RunOnStop
+						atomic.StoreInt32(&rrt.panicStopped, 1)
+						rrt.Stop()
+					}
+					return
+				}
+				if rrt.OnPanicStack != nil {
+					rrt.OnPanicStack(r, stack)
+				}
 				if rrt.OnPanic != nil {
 					rrt.OnPanic(r)
-				} else {
-					fmt.Printf("recovered: %v, stack: %s\n", r, debug.Stack())
+				}
+				if rrt.OnPanic == nil && rrt.OnPanicStack == nil {
+					prefix := ""
+					if name := rrt.Name(); name != "" {
+						prefix = name + ": "
+					}
+					fmt.Printf("%srecovered: %v, stack: %s\n", prefix, r, stack)
 				}
 			}
 		}()
 	}
+	if rc, ok := runner.(RunnerCtx); ok {
+		return false, rc.IntervalRunCtx(ctx)
+	}
+	return false, runner.IntervalRun()
+}
 
-	var err error
+func (rrt *IntervalRoutine) runSafe() bool {
 	var timerC <-chan time.Time
-	if rrt.currentInterval > 0 {
-		timer := time.NewTimer(rrt.currentInterval)
+	if !rrt.nextRun.IsZero() {
+		timer := time.NewTimer(time.Until(rrt.nextRun))
 		timerC = timer.C
 		defer timer.Stop()
 	}
+	var scheduleC <-chan time.Time
+	sc := rrt.nextSchedule()
+	if sc != nil {
+		timer := time.NewTimer(time.Until(sc.next))
+		scheduleC = timer.C
+		defer timer.Stop()
+	}
 
+	if rrt.OnLoopIdle != nil {
+		rrt.OnLoopIdle()
+	}
+	scheduled := false
+	reason := ScheduleTimer
 	select {
 	case <-timerC:
-		select {
-		case <-rrt.done:
+		rrt.checkClockSkew()
+	case <-rrt.force:
+		reason = ScheduleForce
+	case <-scheduleC:
+		scheduled = true
+	case o := <-rrt.completed:
+		// a ConcurrentRoutine worker completed, the next run is scheduled from its outcome
+		return rrt.complete(o)
+	case <-rrt.done:
+		rrt.runOnStop()
+		return false
+	}
+	select {
+	case <-rrt.done:
+		rrt.runOnStop()
+		return false
+	default:
+	}
+	if scheduled {
+		rrt.runSchedules()
+		return true
+	}
+
+	if !rrt.throttle() {
+		rrt.runOnStop()
+		return false
+	}
+
+	// this run serves any trigger received so far, whether woken by timer or force,
+	// so only a trigger received during the run schedules another one
+	select {
+	case <-rrt.force:
+	default:
+	}
+	if rrt.ShouldRun != nil && !rrt.ShouldRun() {
+		rrt.skipNonLeader()
+		return true
+	}
+	final := atomic.SwapInt32(&rrt.stopAfterNext, 0) == 1
+	rrt.notifySchedule(reason, rrt.CurrentInterval())
+	rrt.mu.Lock()
+	// nothing is scheduled while running, the next run is armed once it completes
+	rrt.nextRun = time.Time{}
+	rrt.mu.Unlock()
+	if rrt.dispatch != nil {
+		// the run completes on a worker, see ConcurrentRoutine
+		rrt.dispatch()
+		wait := rrt.CurrentInterval()
+		if wait == 0 {
+			wait = rrt.runInterval
+		}
+		rrt.setNextRun(wait, wait)
+		rrt.notifySchedule(ScheduleNormal, wait)
+		if final {
+			rrt.Stop()
 			return false
-		default:
 		}
-		err = rrt.runner.IntervalRun()
-	case <-rrt.force:
+		return true
+	}
+	start := time.Now()
+	panicked, err := rrt.run(rrt.runner)
+	d := time.Since(start)
+	if rrt.runInterval > 0 && d > rrt.runInterval {
+		// runs never overlap, intervals elapsed during the run are skipped, not caught up
+		rrt.mu.Lock()
+		rrt.skipped += int64(d / rrt.runInterval)
+		rrt.mu.Unlock()
+	}
+	ok := rrt.complete(runOutcome{start: start, d: d, panicked: panicked, err: err})
+	if final {
+		// drain mode, this was the last run
+		rrt.Stop()
+		return false
+	}
+	return ok
+}
+
+// runOutcome is the outcome of a run, completed by the routine goroutine or by a ConcurrentRoutine worker.
+type runOutcome struct {
+	start    time.Time
+	d        time.Duration
+	panicked bool
+	err      error
+}
+
+// complete records a run and schedules the next one, it returns false if the routine should stop.
+// The run is published once scheduled, so that Status called from OnEvent or on a result is consistent.
+func (rrt *IntervalRoutine) complete(o runOutcome) bool {
+	recorded := rrt.record(o)
+	ok := false
+	// not scheduled if OnPanicDecide stopped the routine
+	if atomic.LoadInt32(&rrt.panicStopped) == 0 {
+		ok = rrt.schedule(o.panicked, o.err)
+	}
+	rrt.publish(o, recorded)
+	return ok
+}
+
+// record records a run in the status and returns its recorded error.
+func (rrt *IntervalRoutine) record(o runOutcome) error {
+	// ErrRunAgain only affects scheduling, the run is recorded as successful
+	recorded := o.err
+	if errors.Is(recorded, ErrRunAgain) {
+		recorded = nil
+	}
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.lastRun = o.start.Add(o.d)
+	rrt.lastErr = recorded
+	rrt.lastDuration = o.d
+	rrt.runs++
+	if recorded != nil {
+		rrt.errorsTotal++
+	}
+	return recorded
+}
+
+// publish sends a recorded run to Results, OnEvent and OnSlowRun.
+func (rrt *IntervalRoutine) publish(o runOutcome, recorded error) {
+	rrt.publishResult(RunResult{Time: o.start, Duration: o.d, Err: recorded})
+	if rrt.OnEvent != nil {
+		rrt.OnEvent(Event{Type: runEventType(recorded), Time: time.Now(), Name: rrt.Name(), Err: recorded})
+	}
+	if rrt.SlowRunThreshold > 0 && o.d > rrt.SlowRunThreshold && rrt.OnSlowRun != nil {
+		rrt.OnSlowRun(o.d)
+	}
+	rrt.firstRunOnce.Do(func() {
+		close(rrt.firstRun)
+	})
+}
+
+// waitWorkers waits for the runs dispatched to ConcurrentRoutine workers, recording their outcome.
+func (rrt *IntervalRoutine) waitWorkers() {
+	if rrt.dispatch == nil {
+		return
+	}
+	idle := make(chan struct{})
+	go func() {
+		rrt.workers.Wait()
+		close(idle)
+	}()
+	for {
 		select {
-		case <-rrt.done:
-			return false
-		default:
+		case o := <-rrt.completed:
+			rrt.publish(o, rrt.record(o))
+		case <-idle:
+			return
 		}
-		err = rrt.runner.IntervalRun()
+	}
+}
+
+// skipNonLeader skips a run refused by ShouldRun, the next run is armed at the run interval.
+func (rrt *IntervalRoutine) skipNonLeader() {
+	rrt.mu.Lock()
+	rrt.skippedNonLeader++
+	rrt.mu.Unlock()
+	next := rrt.runInterval
+	if rrt.NextInterval != nil {
+		next = rrt.NextInterval(time.Now())
+	}
+	rrt.setNextRun(rrt.CurrentInterval(), next)
+	rrt.notifySchedule(ScheduleNormal, next)
+}
+
+// throttle waits for MinRunInterval since the last run, it returns false if stopped meanwhile.
+func (rrt *IntervalRoutine) throttle() bool {
+	if rrt.MinRunInterval <= 0 {
+		return true
+	}
+	wait := rrt.MinRunInterval - time.Since(rrt.LastRunTime())
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
 	case <-rrt.done:
 		return false
 	}
+}
+
+// runOnStop runs a final time after Stop, if RunOnStop is set or StopAndRunFinal was called.
+func (rrt *IntervalRoutine) runOnStop() {
+	if atomic.LoadInt32(&rrt.panicStopped) == 1 {
+		// stopped by itself after a fatal panic of a schedule
+		return
+	}
+	// the final run comes after the runs in progress on workers
+	rrt.waitWorkers()
+	rrt.mu.RLock()
+	ctx := rrt.finalCtx
+	rrt.mu.RUnlock()
+	if !rrt.RunOnStop && ctx == nil {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := rrt.runCtx(ctx, rrt.runner)
+	rrt.mu.Lock()
+	rrt.lastRun = time.Now()
+	rrt.lastErr = err
+	rrt.finalErr = err
+	rrt.mu.Unlock()
+}
 
-	if err != nil && rrt.retryInterval > 0 {
-		retryInterval := rrt.retryInterval
-		// rrt.currentInterval == rrt.runInterval on the first retry only
-		if !rrt.RetryBackoffDisabled && rrt.currentInterval > 0 && rrt.currentInterval < rrt.runInterval {
-			// backoff, starting from rrt.retryInterval, up to rrt.runInterval
-			retryInterval = rrt.currentInterval * 2
-			if retryInterval >= rrt.runInterval {
-				// set the interval just under run interval to differentiate
-				retryInterval = rrt.runInterval - 1
+// schedule sets up the next run based on the outcome of the last one.
+// It returns false if the routine should stop.
+func (rrt *IntervalRoutine) schedule(panicked bool, err error) bool {
+	if panicked {
+		rrt.consecutivePanics++
+		if rrt.MaxConsecutivePanics > 0 && rrt.consecutivePanics >= rrt.MaxConsecutivePanics {
+			// crash loop, give up
+			rrt.Stop()
+			if rrt.OnPanicLimit != nil {
+				rrt.OnPanicLimit(rrt.consecutivePanics)
 			}
+			return false
 		}
-		rrt.currentInterval = retryInterval
+		if rrt.PanicAsErrorDisabled {
+			// keep the current interval
+			cur := rrt.CurrentInterval()
+			rrt.setNextRun(cur, cur)
+			rrt.notifySchedule(ScheduleNormal, cur)
+			return true
+		}
+	} else {
+		rrt.consecutivePanics = 0
+	}
+	runAgain := false
+	if errors.Is(err, ErrRunAgain) {
+		runAgain = true
+		err = nil
+	}
+
+	if err == nil {
+		rrt.succeededOnce.Do(func() {
+			close(rrt.succeeded)
+		})
+	}
+
+	fastRetry := err != nil && (rrt.ShouldRetryFast == nil || rrt.ShouldRetryFast(err))
+	recovered := 0
+	rrt.mu.Lock()
+	if fastRetry {
+		rrt.consecutiveErrors++
+		rrt.consecutiveOK = 0
+	} else if err != nil {
+		rrt.consecutiveErrors = 0
 	} else {
-		rrt.currentInterval = rrt.runInterval
+		rrt.consecutiveOK++
+		if rrt.consecutiveOK >= rrt.SuccessesToResetBackoff {
+			recovered = rrt.consecutiveErrors
+			rrt.consecutiveErrors = 0
+		}
+	}
+	if err == nil {
+		rrt.failingSince = time.Time{}
+	} else if rrt.failingSince.IsZero() {
+		rrt.failingSince = rrt.now()
+	}
+	failingFor := rrt.now().Sub(rrt.failingSince)
+	consecutiveErrors := rrt.consecutiveErrors
+	retry := rrt.retryInterval
+	backoff := rrt.backoff
+	boost := rrt.boostInterval
+	boosted := boost > 0 && rrt.now().Before(rrt.boostUntil)
+	rrt.mu.Unlock()
+
+	if recovered > 0 && rrt.OnRecovered != nil {
+		rrt.OnRecovered(recovered)
+	}
+
+	if err == nil && !runAgain && rrt.RunOnceMode {
+		// done for good
+		rrt.Stop()
+		return false
+	}
+	giveUp := rrt.MaxRetries > 0 && consecutiveErrors > rrt.MaxRetries
+	giveUp = giveUp || (rrt.MaxTotalRetryDuration > 0 && failingFor >= rrt.MaxTotalRetryDuration)
+	if err != nil && giveUp {
+		rrt.Stop()
+		if rrt.OnGiveUp != nil {
+			rrt.OnGiveUp(err)
+		}
+		return false
+	}
+
+	next := rrt.runInterval
+	if rrt.NextInterval != nil {
+		next = rrt.NextInterval(time.Now())
+	}
+	reason := ScheduleNormal
+	maxRetry := rrt.MaxRetryInterval
+	if maxRetry <= 0 {
+		maxRetry = next
 	}
+	if boosted && boost < next {
+		next = boost
+	}
+	if fastRetry && retry > 0 && retry <= maxRetry {
+		next = retry
+		reason = ScheduleRetryBackoff
+		if !rrt.RetryBackoffDisabled && consecutiveErrors > 1 && backoff > 0 {
+			// backoff, starting from retry, up to maxRetry
+			next = backoff * 2
+			if next > maxRetry {
+				next = maxRetry
+			}
+		}
+		rrt.mu.Lock()
+		rrt.backoff = next
+		rrt.mu.Unlock()
+	}
+	wait := next
+	if runAgain {
+		// one-off wait, leaves the current interval and backoff untouched
+		wait = runAgainDelay
+		reason = ScheduleForce
+	}
+	rrt.setNextRun(next, wait)
+	rrt.notifySchedule(reason, wait)
 	return true
 }
+
+// notifySchedule calls OnSchedule if set.
+func (rrt *IntervalRoutine) notifySchedule(reason ScheduleReason, interval time.Duration) {
+	if rrt.OnSchedule != nil {
+		rrt.OnSchedule(reason, interval)
+	}
+}
+
+// jitter randomizes d by up to the fraction f in either direction.
+func jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 {
+		return d
+	}
+	if f > 1 {
+		f = 1
+	}
+	return d + time.Duration(f*(2*rand.Float64()-1)*float64(d))
+}
+
+// setNextRun records the current interval and arms the next run after wait, 0 meaning on trigger only.
+func (rrt *IntervalRoutine) setNextRun(interval time.Duration, wait time.Duration) {
+	// only written by the routine goroutine, locked for readers
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.currentInterval = interval
+	rrt.nextRun = time.Time{}
+	rrt.armedWait = 0
+	if wait > 0 {
+		rrt.armedWait = jitter(wait, rrt.Jitter)
+		if floor := rrt.minInterval(); rrt.armedWait < floor {
+			rrt.armedWait = floor
+		}
+		rrt.nextRun = time.Now().Add(rrt.armedWait)
+		// wall clock, the monotonic clock may not advance while suspended
+		rrt.armedAt = rrt.now().Round(0)
+	}
+}
+
+// minInterval returns the floor of the waits between runs, 0 if disabled.
+func (rrt *IntervalRoutine) minInterval() time.Duration {
+	switch {
+	case rrt.MinInterval < 0:
+		return 0
+	case rrt.MinInterval > 0:
+		return rrt.MinInterval
+	}
+	return DefaultMinInterval
+}
+
+// checkClockSkew compares the wall time elapsed since the timer was armed to the armed wait, once the timer fired.
+func (rrt *IntervalRoutine) checkClockSkew() {
+	tolerance := rrt.ClockSkewTolerance
+	if tolerance <= 0 {
+		tolerance = time.Second
+	}
+	rrt.mu.Lock()
+	expected := rrt.armedWait
+	actual := rrt.now().Round(0).Sub(rrt.armedAt)
+	skewed := expected > 0 && actual-expected > tolerance
+	if skewed {
+		rrt.clockSkews++
+	}
+	rrt.mu.Unlock()
+	if skewed && rrt.OnClockSkew != nil {
+		rrt.OnClockSkew(expected, actual)
+	}
+}
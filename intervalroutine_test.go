@@ -1,7 +1,13 @@
 package goodroutine
 
 import (
+	"context"
 	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -229,3 +235,1388 @@ func TestRetryInterval(t *testing.T) {
 	case <-time.Tick(run):
 	}
 }
+
+func TestTriggerOnSignal(t *testing.T) {
+	called := make(chan bool)
+	f := func() error {
+		called <- true
+		return nil
+	}
+	// keep the signal from killing the test process once unregistered
+	sink := make(chan os.Signal, 10)
+	signal.Notify(sink, syscall.SIGHUP)
+	defer signal.Stop(sink)
+
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	stop := rt.TriggerOnSignal(syscall.SIGHUP)
+	rt.Start()
+	defer rt.Stop()
+	// should be called at start
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("cannot send signal: %v", err)
+	}
+	select {
+	case <-called:
+	case <-time.Tick(100 * time.Millisecond):
+		t.Error("function was not called on signal")
+	}
+
+	// no more runs once unregistered
+	stop()
+	stop()
+	rt.Stop()
+	p.Signal(syscall.SIGHUP)
+	select {
+	case <-called:
+		t.Error("function called after stop")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+}
+
+func TestIsRunning(t *testing.T) {
+	called := make(chan bool)
+	barrier := make(chan bool)
+	f := func() error {
+		called <- true
+		<-barrier
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	if rt.IsRunning() {
+		t.Error("should not be running before start")
+	}
+	rt.Start()
+	defer rt.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-called:
+		case <-time.Tick(10 * time.Millisecond):
+			t.Error("function was not called")
+		}
+		if !rt.IsRunning() {
+			t.Error("should be running")
+		}
+		barrier <- true
+		time.Sleep(10 * time.Millisecond)
+		if rt.IsRunning() {
+			t.Error("should not be running after run")
+		}
+		rt.TriggerRun()
+	}
+}
+
+func TestRunAgain(t *testing.T) {
+	called := make(chan bool)
+	count := 0
+	f := func() error {
+		called <- true
+		count++
+		if count < 4 {
+			return ErrRunAgain
+		}
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, 0)
+	rt.Start()
+	defer rt.Stop()
+
+	// should be called at start, then again right away
+	for i := 0; i < 4; i++ {
+		select {
+		case <-called:
+		case <-time.Tick(10 * time.Millisecond):
+			t.Error("function was not called")
+		}
+	}
+	select {
+	case <-called:
+		t.Error("function was called too many times")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+}
+
+func TestMaxConsecutivePanics(t *testing.T) {
+	called := make(chan bool)
+	limit := make(chan int, 1)
+	f := func() error {
+		called <- true
+		panic("blah")
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	rt.OnPanic = func(recovered interface{}) {}
+	rt.OnPanicLimit = func(numPanics int) {
+		limit <- numPanics
+	}
+	rt.MaxConsecutivePanics = 3
+	rt.Start()
+	defer rt.Stop()
+
+	// panics do not stop the routine until the limit
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			rt.TriggerRun()
+		}
+		select {
+		case <-called:
+		case <-time.Tick(10 * time.Millisecond):
+			t.Error("function was not called")
+		}
+	}
+	select {
+	case n := <-limit:
+		if g, w := n, 3; g != w {
+			t.Errorf("Incorrect param, got=%v, want=%v", g, w)
+		}
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("limit callback was not called")
+	}
+
+	rt.TriggerRun()
+	select {
+	case <-called:
+		t.Error("function called after panic limit")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+}
+
+func TestDone(t *testing.T) {
+	called := make(chan bool)
+	barrier := make(chan bool)
+	f := func() error {
+		called <- true
+		<-barrier
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	rt.Start()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	// stopping while the function runs does not exit yet
+	go rt.Stop()
+	rt.Stop()
+	select {
+	case <-rt.Done():
+		t.Error("routine exited during run")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+
+	close(barrier)
+	select {
+	case <-rt.Done():
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("routine did not exit")
+	}
+}
+
+func TestTriggerDuringRun(t *testing.T) {
+	called := make(chan bool)
+	barrier := make(chan bool, 1)
+	f := func() error {
+		called <- true
+		<-barrier
+		return nil
+	}
+	interval := 50 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), interval, 0)
+	rt.Start()
+	defer rt.Stop()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	// many triggers while running cause a single run
+	for i := 0; i < 10; i++ {
+		rt.TriggerRun()
+	}
+	barrier <- true
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+	barrier <- true
+
+	// next call only at interval
+	select {
+	case <-called:
+		t.Error("function was called too many times")
+	case <-time.Tick(interval / 2):
+	}
+	select {
+	case <-called:
+	case <-time.Tick(interval):
+		t.Error("function was not called at interval")
+	}
+	barrier <- true
+}
+
+func TestRunnerCtxFunc(t *testing.T) {
+	called := make(chan bool)
+	cancelled := make(chan error)
+	f := func(ctx context.Context) error {
+		called <- true
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerCtxFunc(f), 0, 0)
+	rt.Start()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	rt.Stop()
+	select {
+	case err := <-cancelled:
+		if g, w := err, context.Canceled; g != w {
+			t.Errorf("Error does not match, got=%v, want=%v", g, w)
+		}
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("context was not cancelled on stop")
+	}
+}
+
+func TestStopAfterNextRun(t *testing.T) {
+	called := make(chan bool)
+	f := func() error {
+		called <- true
+		return errors.New("error")
+	}
+	retry := 20 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, retry)
+	rt.Start()
+	defer rt.Stop()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	rt.StopAfterNextRun()
+	// exactly one more run, at the retry interval
+	select {
+	case <-called:
+	case <-time.Tick(2 * retry):
+		t.Error("function was not called")
+	}
+	select {
+	case <-rt.Done():
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("routine did not exit")
+	}
+	rt.TriggerRun()
+	select {
+	case <-called:
+		t.Error("function called after final run")
+	case <-time.Tick(4 * retry):
+	}
+}
+
+func TestCurrentInterval(t *testing.T) {
+	called := make(chan bool)
+	f := func() error {
+		called <- true
+		return errors.New("error")
+	}
+	run := time.Second
+	retry := 10 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), run, retry)
+	if g := rt.CurrentInterval(); g != 0 {
+		t.Errorf("Interval should be 0 before start, got=%v", g)
+	}
+	rt.Start()
+	defer rt.Stop()
+
+	want := retry
+	for i := 0; i < 4; i++ {
+		select {
+		case <-called:
+		case <-time.Tick(2 * want):
+			t.Error("function was not called")
+		}
+		time.Sleep(time.Millisecond)
+		if g, w := rt.CurrentInterval(), want; g != w {
+			t.Errorf("Interval does not match at i=%d, got=%v, want=%v", i, g, w)
+		}
+		want *= 2
+	}
+}
+
+func TestPanicAsError(t *testing.T) {
+	called := make(chan bool)
+	f := func() error {
+		called <- true
+		panic("blah")
+	}
+	run := time.Hour
+	retry := 10 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), run, retry)
+	rt.OnPanic = func(recovered interface{}) {}
+	rt.Start()
+	defer rt.Stop()
+
+	// panics are retried like errors
+	for i := 0; i < 2; i++ {
+		select {
+		case <-called:
+		case <-time.Tick(2 * retry):
+			t.Error("function was not called")
+		}
+	}
+	time.Sleep(time.Millisecond)
+	if g, w := rt.CurrentInterval(), 2*retry; g != w {
+		t.Errorf("Interval does not match, got=%v, want=%v", g, w)
+	}
+}
+
+func TestStartStopped(t *testing.T) {
+	f := func() error {
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	if !rt.Start() {
+		t.Error("first start should start the routine")
+	}
+	if rt.Start() {
+		t.Error("second start should be a no-op")
+	}
+	if rt.Stopped() {
+		t.Error("routine should not be stopped")
+	}
+	rt.Stop()
+	if !rt.Stopped() {
+		t.Error("routine should be stopped")
+	}
+}
+
+func TestInitialDelay(t *testing.T) {
+	called := make(chan time.Time)
+	f := func() error {
+		called <- time.Now()
+		return nil
+	}
+	delay := 20 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, 0)
+	rt.InitialDelay = delay
+	rt.InitialDelayMax = 2 * delay
+	start := time.Now()
+	rt.Start()
+	defer rt.Stop()
+
+	select {
+	case now := <-called:
+		if d := now.Sub(start); d < delay || d > 3*delay {
+			t.Errorf("first run not within initial delay, got=%v", d)
+		}
+	case <-time.Tick(4 * delay):
+		t.Error("function was not called")
+	}
+
+	// trigger during the delay runs right away
+	rt = NewIntervalRoutine(RunnerFunc(f), time.Hour, 0)
+	rt.InitialDelay = time.Hour
+	rt.Start()
+	defer rt.Stop()
+	select {
+	case <-called:
+		t.Error("function called before initial delay")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+	rt.TriggerRun()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+}
+
+func TestWaitForFirstRun(t *testing.T) {
+	barrier := make(chan bool)
+	f := func() error {
+		<-barrier
+		return errors.New("error")
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, 0)
+	rt.Start()
+	defer rt.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if g, w := rt.WaitForFirstRun(ctx), context.DeadlineExceeded; g != w {
+		t.Errorf("Error does not match, got=%v, want=%v", g, w)
+	}
+
+	close(barrier)
+	if err := rt.WaitForFirstRun(context.Background()); err != nil {
+		t.Errorf("Unexpected error, got=%v", err)
+	}
+}
+
+func TestRoutineOnEvent(t *testing.T) {
+	events := make(chan Event, 10)
+	count := 0
+	f := func() error {
+		count++
+		switch count {
+		case 1:
+			return nil
+		case 2:
+			return errors.New("error")
+		}
+		panic("blah")
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	rt.OnPanic = func(recovered interface{}) {}
+	rt.OnEvent = func(ev Event) {
+		events <- ev
+	}
+	rt.Start()
+	defer rt.Stop()
+
+	for _, w := range []EventType{EventRunOK, EventRunError, EventPanic} {
+		select {
+		case ev := <-events:
+			if g := ev.Type; g != w {
+				t.Errorf("Event does not match, got=%v, want=%v", g, w)
+			}
+		case <-time.Tick(10 * time.Millisecond):
+			t.Error("event not received")
+		}
+		rt.TriggerRun()
+	}
+}
+
+func TestMaxRetryInterval(t *testing.T) {
+	called := make(chan bool)
+	f := func() error {
+		called <- true
+		return errors.New("error")
+	}
+	retry := 5 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, retry)
+	rt.MaxRetryInterval = 4 * retry
+	rt.Start()
+	defer rt.Stop()
+
+	for _, w := range []time.Duration{retry, 2 * retry, 4 * retry, 4 * retry} {
+		select {
+		case <-called:
+		case <-time.Tick(8 * retry):
+			t.Error("function was not called")
+		}
+		time.Sleep(time.Millisecond)
+		if g := rt.CurrentInterval(); g != w {
+			t.Errorf("Interval does not match, got=%v, want=%v", g, w)
+		}
+	}
+
+	// retry may exceed the run interval when capped separately
+	called2 := make(chan bool, 1)
+	rt = NewIntervalRoutine(RunnerFunc(func() error {
+		called2 <- true
+		return errors.New("error")
+	}), time.Millisecond, retry)
+	rt.MaxRetryInterval = time.Hour
+	rt.Start()
+	defer rt.Stop()
+	select {
+	case <-called2:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+	time.Sleep(time.Millisecond)
+	if g, w := rt.CurrentInterval(), retry; g != w {
+		t.Errorf("Interval does not match, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOnLoopIdle(t *testing.T) {
+	var runs atomic.Int32
+	f := func() error {
+		runs.Add(1)
+		return nil
+	}
+	idle := make(chan bool)
+	quit := make(chan bool)
+	rt := NewIntervalRoutine(RunnerFunc(f), 0, 0)
+	rt.OnLoopIdle = func() {
+		select {
+		case idle <- true:
+		case <-quit:
+		}
+	}
+	rt.Start()
+	defer func() {
+		close(quit)
+		rt.StopAndWait(context.Background())
+	}()
+
+	// the first idle happens before the initial run, which is already pending
+	<-idle
+	for i := 1; i <= 3; i++ {
+		// wait for the routine to park, no sleep needed
+		<-idle
+		if g, w := runs.Load(), int32(i); g != w {
+			t.Errorf("Incorrect runs, got=%d, want=%d", g, w)
+		}
+		if i < 3 {
+			rt.TriggerRun()
+		}
+	}
+}
+
+func TestSkippedRunCount(t *testing.T) {
+	slow := make(chan bool, 1)
+	f := func() error {
+		select {
+		case <-slow:
+			time.Sleep(35 * time.Millisecond)
+		default:
+		}
+		return nil
+	}
+	interval := 10 * time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), interval, 0)
+	rt.Start()
+	defer rt.Stop()
+	rt.WaitForFirstRun(context.Background())
+	if g := rt.SkippedRunCount(); g != 0 {
+		t.Errorf("Incorrect skipped runs, got=%v", g)
+	}
+
+	slow <- true
+	time.Sleep(60 * time.Millisecond)
+	if g, w := rt.SkippedRunCount(), int64(3); g != w {
+		t.Errorf("Incorrect skipped runs, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOnPanicStack(t *testing.T) {
+	stacks := make(chan []byte, 1)
+	f := func() error {
+		panic("blah")
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, 0)
+	rt.OnPanicStack = func(recovered interface{}, stack []byte) {
+		if g, w := recovered, "blah"; g != w {
+			t.Errorf("Recovered value does not match, got=%v, want=%v", g, w)
+		}
+		stacks <- stack
+	}
+	rt.Start()
+	defer rt.Stop()
+
+	select {
+	case stack := <-stacks:
+		if !strings.Contains(string(stack), "TestOnPanicStack") {
+			t.Errorf("Stack does not contain the panicking function: %s", stack)
+		}
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("OnPanicStack was not called")
+	}
+}
+
+func TestNewIntervalRoutineFromStrings(t *testing.T) {
+	f := RunnerFunc(func() error {
+		return nil
+	})
+	rt, err := NewIntervalRoutineFromStrings(f, "5m", "30s")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := rt.runInterval, 5*time.Minute; g != w {
+		t.Errorf("Incorrect run interval, got=%v, want=%v", g, w)
+	}
+	if g, w := rt.retryInterval, 30*time.Second; g != w {
+		t.Errorf("Incorrect retry interval, got=%v, want=%v", g, w)
+	}
+
+	for _, c := range []struct {
+		run, retry, field string
+	}{
+		{"5x", "30s", "run interval"},
+		{"5m", "-1s", "retry interval"},
+		{"30s", "5m", "retry interval"},
+	} {
+		_, err := NewIntervalRoutineFromStrings(f, c.run, c.retry)
+		if err == nil || !strings.Contains(err.Error(), c.field) {
+			t.Errorf("Error should name %v, got=%v", c.field, err)
+		}
+	}
+}
+
+func TestDrainTriggers(t *testing.T) {
+	called := make(chan bool)
+	barrier := make(chan bool, 1)
+	f := func() error {
+		called <- true
+		<-barrier
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, 0)
+	rt.Start()
+	defer rt.Stop()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	// trigger during the run is discarded
+	rt.TriggerRun()
+	rt.DrainTriggers()
+	barrier <- true
+	select {
+	case <-called:
+		t.Error("drained trigger should not run")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+
+	// later trigger still runs
+	rt.TriggerRun()
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+	barrier <- true
+}
+
+func TestShouldRetryFast(t *testing.T) {
+	errBusy := errors.New("busy")
+	errFailed := errors.New("failed")
+	runErr := errFailed
+	f := func() error {
+		return runErr
+	}
+	retry := time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, retry)
+	rt.ShouldRetryFast = func(err error) bool {
+		return !errors.Is(err, errBusy)
+	}
+
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), retry; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), 2*retry; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+
+	// expected error waits normally and resets the backoff
+	runErr = errBusy
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), time.Hour; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+	runErr = errFailed
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), retry; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+}
+
+func TestStopAt(t *testing.T) {
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0)
+	rt.Start()
+	rt.StopAt(time.Now().Add(5 * time.Millisecond))
+	select {
+	case <-rt.Done():
+	case <-time.Tick(20 * time.Millisecond):
+		t.Error("routine was not stopped")
+	}
+
+	rt = NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0)
+	rt.Start()
+	defer rt.Stop()
+	rt.StopAt(time.Now().Add(5 * time.Millisecond))
+	rt.CancelStopAt()
+	select {
+	case <-rt.Done():
+		t.Error("routine should not be stopped")
+	case <-time.Tick(20 * time.Millisecond):
+	}
+}
+
+func TestRun(t *testing.T) {
+	called := make(chan bool, 1)
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		called <- true
+		return nil
+	}), time.Hour, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- rt.Run(ctx)
+	}()
+
+	select {
+	case <-called:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+	if g, w := rt.Run(ctx), ErrAlreadyStarted; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+	cancel()
+	select {
+	case err := <-errc:
+		if g, w := err, context.Canceled; g != w {
+			t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+		}
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("Run did not return")
+	}
+	if !rt.Stopped() {
+		t.Error("routine should be stopped")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	d := 10 * time.Second
+	if g, w := jitter(d, 0), d; g != w {
+		t.Errorf("Incorrect jitter, got=%v, want=%v", g, w)
+	}
+	spread := false
+	for i := 0; i < 100; i++ {
+		g := jitter(d, 0.1)
+		if g < 9*time.Second || g > 11*time.Second {
+			t.Errorf("Jitter out of range, got=%v", g)
+		}
+		spread = spread || g != d
+	}
+	if !spread {
+		t.Error("Jitter should randomize the wait")
+	}
+}
+
+func TestRunOnStop(t *testing.T) {
+	var count int32
+	ctxErrs := make(chan error, 2)
+	f := func(ctx context.Context) error {
+		atomic.AddInt32(&count, 1)
+		ctxErrs <- ctx.Err()
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerCtxFunc(f), time.Hour, 0)
+	rt.RunOnStop = true
+	rt.Start()
+	select {
+	case <-ctxErrs:
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("function was not called")
+	}
+
+	if err := rt.StopAndWait(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if g, w := atomic.LoadInt32(&count), int32(2); g != w {
+		t.Errorf("Incorrect run count, got=%v, want=%v", g, w)
+	}
+	if err := <-ctxErrs; err != nil {
+		t.Errorf("Final run context should not be cancelled, got=%v", err)
+	}
+}
+
+func TestResetBackoff(t *testing.T) {
+	retry := time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return errors.New("error")
+	}), time.Hour, retry)
+
+	for _, w := range []time.Duration{retry, 2 * retry, 4 * retry} {
+		rt.schedule(rt.run(rt.runner))
+		if g := rt.CurrentInterval(); g != w {
+			t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+		}
+	}
+
+	rt.ResetBackoff()
+	if g, w := rt.CurrentInterval(), time.Hour; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+	rt.SetRetryInterval(3 * retry)
+	for _, w := range []time.Duration{3 * retry, 6 * retry} {
+		rt.schedule(rt.run(rt.runner))
+		if g := rt.CurrentInterval(); g != w {
+			t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+		}
+	}
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	called := make(chan bool, 1)
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		called <- true
+		return nil
+	}), time.Hour, 0)
+	rt.Stop()
+	select {
+	case <-rt.Done():
+	default:
+		t.Error("Done should be closed")
+	}
+	if rt.Start() {
+		t.Error("Start after Stop should be a no-op")
+	}
+	select {
+	case <-called:
+		t.Error("function should never run")
+	case <-time.Tick(10 * time.Millisecond):
+	}
+}
+
+func TestOnSchedule(t *testing.T) {
+	runErr := errors.New("error")
+	retry := time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return runErr
+	}), time.Hour, retry)
+	type decision struct {
+		reason   ScheduleReason
+		interval time.Duration
+	}
+	var decisions []decision
+	rt.OnSchedule = func(reason ScheduleReason, nextInterval time.Duration) {
+		decisions = append(decisions, decision{reason, nextInterval})
+	}
+
+	rt.schedule(rt.run(rt.runner))
+	runErr = nil
+	rt.schedule(rt.run(rt.runner))
+	runErr = ErrRunAgain
+	rt.schedule(rt.run(rt.runner))
+
+	want := []decision{
+		{ScheduleRetryBackoff, retry},
+		{ScheduleNormal, time.Hour},
+		{ScheduleForce, runAgainDelay},
+	}
+	if len(decisions) != len(want) {
+		t.Fatalf("Incorrect decisions, got=%v, want=%v", decisions, want)
+	}
+	for i, w := range want {
+		if g := decisions[i]; g != w {
+			t.Errorf("Incorrect decision %d, got=%v, want=%v", i, g, w)
+		}
+	}
+	if g, w := ScheduleRetryBackoff.String(), "retry_backoff"; g != w {
+		t.Errorf("Incorrect string, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOneShotRoutine(t *testing.T) {
+	calls := 0
+	rt := NewOneShotRoutine(RunnerFunc(func() error {
+		calls++
+		if calls <= 2 {
+			return errors.New("error")
+		}
+		return nil
+	}), time.Millisecond)
+	rt.Start()
+	select {
+	case <-rt.Done():
+	case <-time.Tick(50 * time.Millisecond):
+		t.Fatal("routine did not stop")
+	}
+	if g, w := calls, 3; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+	if rt.LastErr() != nil {
+		t.Errorf("Last run should succeed, got=%v", rt.LastErr())
+	}
+}
+
+func TestOneShotRoutineNoRetryInterval(t *testing.T) {
+	rt := NewOneShotRoutine(RunnerFunc(func() error {
+		return errors.New("error")
+	}), 0)
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), time.Second; g != w {
+		t.Errorf("Failed run should be retried, got=%v, want=%v", g, w)
+	}
+	if rt.Stopped() {
+		t.Error("routine should not stop on error")
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	runErr := errors.New("error")
+	calls := 0
+	rt := NewOneShotRoutine(RunnerFunc(func() error {
+		calls++
+		return runErr
+	}), time.Millisecond)
+	rt.MaxRetries = 2
+	gaveUp := make(chan error, 1)
+	rt.OnGiveUp = func(err error) {
+		gaveUp <- err
+	}
+	rt.Start()
+	select {
+	case err := <-gaveUp:
+		if err != runErr {
+			t.Errorf("Incorrect error, got=%v, want=%v", err, runErr)
+		}
+	case <-time.Tick(50 * time.Millisecond):
+		t.Fatal("routine did not give up")
+	}
+	<-rt.Done()
+	if g, w := calls, 3; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOnPanicDecide(t *testing.T) {
+	errFatal := errors.New("fatal")
+	calls := 0
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		calls++
+		if calls == 1 {
+			panic("recoverable")
+		}
+		panic(errFatal)
+	}), time.Hour, time.Millisecond)
+	onPanic := false
+	rt.OnPanic = func(recovered interface{}) {
+		onPanic = true
+	}
+	rt.OnPanicDecide = func(recovered interface{}, stack []byte) bool {
+		return recovered != errFatal
+	}
+	rt.Start()
+	select {
+	case <-rt.Done():
+	case <-time.Tick(50 * time.Millisecond):
+		t.Fatal("routine did not stop")
+	}
+	if g, w := calls, 2; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+	if onPanic {
+		t.Error("OnPanic should not be called when OnPanicDecide is set")
+	}
+}
+
+func TestOnPanicDecideRunOnStop(t *testing.T) {
+	var calls int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		panic("fatal")
+	}), time.Hour, 0)
+	rt.RunOnStop = true
+	rt.OnPanicDecide = func(recovered interface{}, stack []byte) bool {
+		return false
+	}
+	rt.Start()
+	select {
+	case <-rt.Done():
+	case <-time.After(time.Second):
+		t.Fatal("routine did not stop")
+	}
+	if g, w := atomic.LoadInt32(&calls), int32(1); g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+}
+
+func TestLastPanic(t *testing.T) {
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		panic("nil map assignment")
+	}), time.Hour, 0)
+	rt.OnPanic = func(recovered interface{}) {}
+	if value, _, _ := rt.LastPanic(); value != nil {
+		t.Errorf("No panic expected, got=%v", value)
+	}
+
+	rt.run(rt.runner)
+	value, stack, when := rt.LastPanic()
+	if g, w := value, "nil map assignment"; g != w {
+		t.Errorf("Incorrect panic value, got=%v, want=%v", g, w)
+	}
+	if len(stack) == 0 || when.IsZero() {
+		t.Error("Stack and time should be set")
+	}
+
+	rt.ClearLastPanic()
+	if value, _, _ := rt.LastPanic(); value != nil {
+		t.Errorf("Panic should be cleared, got=%v", value)
+	}
+}
+
+func TestState(t *testing.T) {
+	barrier := make(chan bool)
+	called := make(chan bool)
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		called <- true
+		<-barrier
+		return nil
+	}), time.Hour, 0)
+	if g, w := rt.State(), StateCreated; g != w {
+		t.Errorf("Incorrect state, got=%v, want=%v", g, w)
+	}
+	rt.Start()
+	<-called
+	if g, w := rt.State(), StateRunning; g != w {
+		t.Errorf("Incorrect state, got=%v, want=%v", g, w)
+	}
+	rt.Stop()
+	if g, w := rt.State(), StateStopping; g != w {
+		t.Errorf("Incorrect state, got=%v, want=%v", g, w)
+	}
+	close(barrier)
+	<-rt.Done()
+	if g, w := rt.State(), StateStopped; g != w {
+		t.Errorf("Incorrect state, got=%v, want=%v", g, w)
+	}
+
+	rt = NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0)
+	rt.Stop()
+	if g, w := rt.State(), StateStopped; g != w {
+		t.Errorf("Incorrect state, got=%v, want=%v", g, w)
+	}
+}
+
+func TestNextInterval(t *testing.T) {
+	runErr := errors.New("error")
+	retry := time.Millisecond
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return runErr
+	}), time.Hour, retry)
+	rt.NextInterval = func(now time.Time) time.Duration {
+		return time.Minute
+	}
+
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), retry; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+	runErr = nil
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), time.Minute; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+}
+
+func TestStopAndRunFinal(t *testing.T) {
+	flushErr := errors.New("flush failed")
+	for _, w := range []error{nil, flushErr} {
+		var calls int32
+		called := make(chan bool, 1)
+		w := w
+		rt := NewIntervalRoutine(RunnerFunc(func() error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				called <- true
+				return nil
+			}
+			return w
+		}), time.Hour, 0)
+		rt.Start()
+		<-called
+
+		if g := rt.StopAndRunFinal(context.Background()); g != w {
+			t.Errorf("Incorrect final error, got=%v, want=%v", g, w)
+		}
+		if g, w := atomic.LoadInt32(&calls), int32(2); g != w {
+			t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+		}
+	}
+}
+
+func TestMinRunInterval(t *testing.T) {
+	var calls int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}), time.Hour, 0)
+	rt.MinRunInterval = 20 * time.Millisecond
+	rt.Start()
+	defer rt.Stop()
+
+	// burst of triggers
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		rt.TriggerRun()
+		time.Sleep(100 * time.Microsecond)
+	}
+	if g := atomic.LoadInt32(&calls); g < 2 || g > 4 {
+		t.Errorf("Incorrect calls, got=%v, want about 3", g)
+	}
+}
+
+func TestSuccessesToResetBackoff(t *testing.T) {
+	runErr := errors.New("error")
+	retry := time.Millisecond
+	for _, c := range []struct {
+		successes int
+		want      time.Duration
+	}{
+		// a single success resets the backoff
+		{0, retry},
+		// a lucky success does not
+		{2, 8 * retry},
+	} {
+		var err error
+		rt := NewIntervalRoutine(RunnerFunc(func() error {
+			return err
+		}), time.Hour, retry)
+		rt.SuccessesToResetBackoff = c.successes
+		err = runErr
+		for i := 0; i < 3; i++ {
+			rt.schedule(rt.run(rt.runner))
+		}
+		if g, w := rt.CurrentInterval(), 4*retry; g != w {
+			t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+		}
+		err = nil
+		rt.schedule(rt.run(rt.runner))
+		if g, w := rt.CurrentInterval(), time.Hour; g != w {
+			t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+		}
+		err = runErr
+		rt.schedule(rt.run(rt.runner))
+		if g, w := rt.CurrentInterval(), c.want; g != w {
+			t.Errorf("Incorrect interval with %d successes, got=%v, want=%v", c.successes, g, w)
+		}
+	}
+}
+
+func TestMaxTotalRetryDuration(t *testing.T) {
+	clock := time.Now()
+
+	runErr := errors.New("error")
+	rt := NewOneShotRoutine(RunnerFunc(func() error {
+		return runErr
+	}), time.Second)
+	rt.clock = func() time.Time {
+		return clock
+	}
+	rt.MaxTotalRetryDuration = 2 * time.Minute
+	var gaveUp error
+	rt.OnGiveUp = func(err error) {
+		gaveUp = err
+	}
+
+	if !rt.schedule(rt.run(rt.runner)) {
+		t.Error("routine should retry")
+	}
+	clock = clock.Add(time.Minute)
+	if !rt.schedule(rt.run(rt.runner)) {
+		t.Error("routine should retry")
+	}
+	clock = clock.Add(time.Minute)
+	if rt.schedule(rt.run(rt.runner)) {
+		t.Error("routine should give up")
+	}
+	if g, w := gaveUp, runErr; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+	if !rt.Stopped() {
+		t.Error("routine should be stopped")
+	}
+}
+
+func TestBoost(t *testing.T) {
+	var calls int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}), time.Hour, time.Minute)
+	rt.Boost(5*time.Millisecond, time.Hour)
+	rt.Start()
+	timeout := time.After(time.Second)
+	for atomic.LoadInt32(&calls) < 3 {
+		select {
+		case <-timeout:
+			t.Fatalf("Routine was not boosted, calls=%v", atomic.LoadInt32(&calls))
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+	rt.Stop()
+	<-rt.Done()
+
+	clock := time.Now()
+	rt = NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, time.Minute)
+	rt.clock = func() time.Time {
+		return clock
+	}
+	rt.Boost(time.Second, time.Minute)
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), time.Second; g != w {
+		t.Errorf("Incorrect boosted interval, got=%v, want=%v", g, w)
+	}
+	clock = clock.Add(time.Minute)
+	rt.schedule(rt.run(rt.runner))
+	if g, w := rt.CurrentInterval(), time.Hour; g != w {
+		t.Errorf("Incorrect interval after boost, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOnRecovered(t *testing.T) {
+	runErr := errors.New("error")
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return runErr
+	}), time.Hour, time.Minute)
+	var recovered []int
+	rt.OnRecovered = func(afterErrors int) {
+		recovered = append(recovered, afterErrors)
+	}
+
+	for i := 0; i < 3; i++ {
+		rt.schedule(rt.run(rt.runner))
+	}
+	runErr = nil
+	rt.schedule(rt.run(rt.runner))
+	rt.schedule(rt.run(rt.runner))
+	if g, w := len(recovered), 1; g != w {
+		t.Fatalf("Incorrect recoveries, got=%v, want=%v", g, w)
+	}
+	if g, w := recovered[0], 3; g != w {
+		t.Errorf("Incorrect recoveries, got=%v, want=%v", g, w)
+	}
+}
+
+func TestOnSlowRun(t *testing.T) {
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}), time.Hour, 0)
+	rt.SlowRunThreshold = 5 * time.Millisecond
+	slow := make(chan time.Duration, 1)
+	rt.OnSlowRun = func(d time.Duration) {
+		slow <- d
+	}
+	rt.Start()
+	defer rt.StopAndWait(context.Background())
+
+	select {
+	case d := <-slow:
+		if d < 20*time.Millisecond {
+			t.Errorf("Incorrect duration, got=%v, want>=%v", d, 20*time.Millisecond)
+		}
+	case <-time.After(time.Second):
+		t.Error("OnSlowRun was not called")
+	}
+}
+
+func TestOnClockSkew(t *testing.T) {
+	clock := time.Now()
+
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Minute, 0)
+	rt.clock = func() time.Time {
+		return clock
+	}
+	var expected, actual time.Duration
+	rt.OnClockSkew = func(e, a time.Duration) {
+		expected, actual = e, a
+	}
+
+	rt.schedule(rt.run(rt.runner))
+	clock = clock.Add(time.Minute)
+	rt.checkClockSkew()
+	if g, w := rt.ClockSkewCount(), int64(0); g != w {
+		t.Errorf("Incorrect skew count, got=%v, want=%v", g, w)
+	}
+
+	// suspended
+	rt.schedule(rt.run(rt.runner))
+	clock = clock.Add(20 * time.Minute)
+	rt.checkClockSkew()
+	if g, w := rt.ClockSkewCount(), int64(1); g != w {
+		t.Errorf("Incorrect skew count, got=%v, want=%v", g, w)
+	}
+	if expected != time.Minute || actual != 20*time.Minute {
+		t.Errorf("Incorrect skew, got=%v/%v, want=%v/%v", expected, actual, time.Minute, 20*time.Minute)
+	}
+}
+
+func TestShouldRun(t *testing.T) {
+	var calls int32
+	var leader int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}), 5*time.Millisecond, 0)
+	rt.ShouldRun = func() bool {
+		return atomic.LoadInt32(&leader) == 1
+	}
+	rt.Start()
+	defer rt.StopAndWait(context.Background())
+
+	timeout := time.After(time.Second)
+	for rt.SkippedAsNonLeader() < 3 {
+		select {
+		case <-timeout:
+			t.Fatalf("Runs were not skipped, skipped=%v", rt.SkippedAsNonLeader())
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+	rt.TriggerRun()
+	if g, w := atomic.LoadInt32(&calls), int32(0); g != w {
+		t.Errorf("Incorrect calls as non leader, got=%v, want=%v", g, w)
+	}
+
+	atomic.StoreInt32(&leader, 1)
+	for atomic.LoadInt32(&calls) < 3 {
+		select {
+		case <-timeout:
+			t.Fatalf("Routine did not run as leader, calls=%v", atomic.LoadInt32(&calls))
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	var calls int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("error")
+	}), time.Nanosecond, time.Nanosecond)
+	rt.MinInterval = 10 * time.Millisecond
+	rt.Start()
+	time.Sleep(50 * time.Millisecond)
+	rt.Stop()
+	<-rt.Done()
+	if g := atomic.LoadInt32(&calls); g < 2 || g > 7 {
+		t.Errorf("Incorrect calls, got=%v, want about 5", g)
+	}
+
+	rt = NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Nanosecond, 0)
+	rt.MinInterval = -1
+	if g, w := rt.minInterval(), time.Duration(0); g != w {
+		t.Errorf("Incorrect floor, got=%v, want=%v", g, w)
+	}
+	rt.MinInterval = 0
+	if g, w := rt.minInterval(), DefaultMinInterval; g != w {
+		t.Errorf("Incorrect floor, got=%v, want=%v", g, w)
+	}
+}
@@ -0,0 +1,72 @@
+package goodroutine
+
+import (
+	"errors"
+	"runtime/debug"
+	"sync"
+)
+
+// MultiRunner implements a Runner that runs several independent functions at each run.
+// All functions are run even if some fail, and their errors are combined with errors.Join,
+// so that the routine retries if any of them failed.
+// A panic in a function is recovered as a PanicError for that function only, the others still run.
+type MultiRunner struct {
+	mu       sync.RWMutex
+	fs       []func() error
+	lastErrs []error
+
+	// Parallel if set to true, the functions are run concurrently, otherwise in order
+	Parallel bool
+}
+
+// NewMultiRunner creates a new MultiRunner running the given functions.
+func NewMultiRunner(fs ...func() error) *MultiRunner {
+	return &MultiRunner{
+		fs:       fs,
+		lastErrs: make([]error, len(fs)),
+	}
+}
+
+// IntervalRun implements the Runner interface
+func (mr *MultiRunner) IntervalRun() error {
+	errs := make([]error, len(mr.fs))
+	if mr.Parallel {
+		var wg sync.WaitGroup
+		for i, f := range mr.fs {
+			wg.Add(1)
+			go func(i int, f func() error) {
+				defer wg.Done()
+				errs[i] = runIsolated(f)
+			}(i, f)
+		}
+		wg.Wait()
+	} else {
+		for i, f := range mr.fs {
+			errs[i] = runIsolated(f)
+		}
+	}
+
+	mr.mu.Lock()
+	copy(mr.lastErrs, errs)
+	mr.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// LastErrs returns the error of the last run of each function, in order, nil for a success.
+func (mr *MultiRunner) LastErrs() []error {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	errs := make([]error, len(mr.lastErrs))
+	copy(errs, mr.lastErrs)
+	return errs
+}
+
+// runIsolated runs f, recovering a panic as a PanicError.
+func runIsolated(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,42 @@
+package goodroutine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMultiRunner(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		err1 := errors.New("error")
+		ran := make([]bool, 3)
+		mr := NewMultiRunner(func() error {
+			ran[0] = true
+			return err1
+		}, func() error {
+			ran[1] = true
+			panic("blah")
+		}, func() error {
+			ran[2] = true
+			return nil
+		})
+		mr.Parallel = parallel
+
+		err := mr.IntervalRun()
+		if !errors.Is(err, err1) {
+			t.Errorf("Combined error should contain err1, got=%v", err)
+		}
+		var pe *PanicError
+		if !errors.As(err, &pe) {
+			t.Errorf("Combined error should contain the panic, got=%v", err)
+		}
+		for i, r := range ran {
+			if !r {
+				t.Errorf("Function %d did not run", i)
+			}
+		}
+		errs := mr.LastErrs()
+		if errs[0] != err1 || errs[1] == nil || errs[2] != nil {
+			t.Errorf("Incorrect last errors, got=%v", errs)
+		}
+	}
+}
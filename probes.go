@@ -0,0 +1,99 @@
+package goodroutine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ProbeChecker distinguishes readiness from liveness, like Kubernetes probes, with two HealthCheckers
+// fed by the same check: Readiness says whether the process should receive traffic, Liveness whether it
+// is healthy enough to keep running.
+// It implements Runner, so it is run at interval by an IntervalRoutine, e.g. NewIntervalRoutine(pc, 10*time.Second, 2*time.Second).
+// The liveness thresholdDown should be much larger than the readiness one, e.g. 3 and 30, so that a failing
+// dependency takes the process out of rotation quickly, while only a prolonged failure gets it restarted.
+// Fields like OnUp are set through Readiness and Liveness.
+type ProbeChecker struct {
+	runner Runner
+
+	Readiness *HealthChecker
+	Liveness  *HealthChecker
+}
+
+// NewProbeChecker creates a new ProbeChecker running the check f.
+// The process starts not ready and live, neither checker uses fast start: readiness goes up after readyUp
+// successful checks, down after readyDown failed checks. A failed first check during warm-up does not fail
+// liveness, it goes down after liveDown failed checks and back up after one successful check.
+func NewProbeChecker(f Runner, readyUp int, readyDown int, liveDown int) *ProbeChecker {
+	ready := NewHealthChecker(nil, false, readyUp, readyDown)
+	ready.FastStart = false
+	live := NewHealthChecker(nil, true, 1, liveDown)
+	live.FastStart = false
+	return &ProbeChecker{
+		runner:    f,
+		Readiness: ready,
+		Liveness:  live,
+	}
+}
+
+// IntervalRun implements the Runner interface
+func (pc *ProbeChecker) IntervalRun() error {
+	return pc.IntervalRunCtx(context.Background())
+}
+
+// IntervalRunCtx implements the RunnerCtx interface, it runs the check and reports it to both checkers.
+// A panic is reported as a failed check, then propagated to the routine as a PanicError keeping its stack.
+func (pc *ProbeChecker) IntervalRunCtx(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pe := recoveredPanic(r)
+			pc.report(pe)
+			panic(pe)
+		}
+	}()
+	if rc, ok := pc.runner.(RunnerCtx); ok {
+		err = rc.IntervalRunCtx(ctx)
+	} else {
+		err = pc.runner.IntervalRun()
+	}
+	pc.report(err)
+	return err
+}
+
+func (pc *ProbeChecker) report(err error) {
+	pc.Readiness.Report(err)
+	pc.Liveness.Report(err)
+}
+
+// IsReady returns true if the process should receive traffic.
+func (pc *ProbeChecker) IsReady() bool {
+	return pc.Readiness.IsUp()
+}
+
+// IsLive returns true if the process is healthy enough to keep running.
+func (pc *ProbeChecker) IsLive() bool {
+	return pc.Liveness.IsUp()
+}
+
+// ReadyHandler returns an http.Handler for the readiness probe, e.g. on "/readyz".
+// It writes the readiness HealthSnapshot as JSON, with status 503 if not ready.
+func (pc *ProbeChecker) ReadyHandler() http.Handler {
+	return snapshotHandler(pc.Readiness)
+}
+
+// LiveHandler returns an http.Handler for the liveness probe, e.g. on "/livez".
+// It writes the liveness HealthSnapshot as JSON, with status 503 if not live.
+func (pc *ProbeChecker) LiveHandler() http.Handler {
+	return snapshotHandler(pc.Liveness)
+}
+
+func snapshotHandler(hc *HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snap := hc.Snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		if !snap.Up {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(snap)
+	})
+}
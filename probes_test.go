@@ -0,0 +1,72 @@
+package goodroutine
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeChecker(t *testing.T) {
+	var runErr error
+	pc := NewProbeChecker(RunnerFunc(func() error {
+		return runErr
+	}), 1, 2, 4)
+
+	// warm-up failure
+	runErr = errors.New("error")
+	pc.IntervalRun()
+	if pc.IsReady() || !pc.IsLive() {
+		t.Errorf("Incorrect state after warm-up failure, ready=%v, live=%v", pc.IsReady(), pc.IsLive())
+	}
+	runErr = nil
+	pc.IntervalRun()
+	if !pc.IsReady() || !pc.IsLive() {
+		t.Errorf("Incorrect state after success, ready=%v, live=%v", pc.IsReady(), pc.IsLive())
+	}
+
+	runErr = errors.New("error")
+	for _, c := range []struct {
+		ready bool
+		live  bool
+	}{{true, true}, {false, true}, {false, true}, {false, false}} {
+		pc.IntervalRun()
+		if g, w := pc.IsReady(), c.ready; g != w {
+			t.Errorf("Incorrect readiness, got=%v, want=%v", g, w)
+		}
+		if g, w := pc.IsLive(), c.live; g != w {
+			t.Errorf("Incorrect liveness, got=%v, want=%v", g, w)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	pc.LiveHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/livez", nil))
+	if g, w := rec.Code, http.StatusServiceUnavailable; g != w {
+		t.Errorf("Incorrect status, got=%v, want=%v", g, w)
+	}
+	runErr = nil
+	pc.IntervalRun()
+	rec = httptest.NewRecorder()
+	pc.LiveHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/livez", nil))
+	if g, w := rec.Code, http.StatusOK; g != w {
+		t.Errorf("Incorrect status, got=%v, want=%v", g, w)
+	}
+	rec = httptest.NewRecorder()
+	pc.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if g, w := rec.Code, http.StatusOK; g != w {
+		t.Errorf("Incorrect status, got=%v, want=%v", g, w)
+	}
+}
+
+func TestProbeCheckerReadyUp(t *testing.T) {
+	pc := NewProbeChecker(RunnerFunc(func() error {
+		return nil
+	}), 3, 1, 1)
+
+	for _, ready := range []bool{false, false, true} {
+		pc.IntervalRun()
+		if g, w := pc.IsReady(), ready; g != w {
+			t.Errorf("Incorrect readiness, got=%v, want=%v", g, w)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package goodroutine
+
+import "time"
+
+// resultsBufferSize is the capacity of the Results channel.
+const resultsBufferSize = 16
+
+// RunResult is the outcome of a run, see IntervalRoutine.Results.
+type RunResult struct {
+	// Time is the start time of the run
+	Time     time.Time
+	Duration time.Duration
+	// Err is the error of the run, a *PanicError for a recovered panic
+	Err error
+}
+
+// Results returns a channel receiving the result of each run, created on the first call.
+// The channel is buffered and never blocks the routine: if the consumer is slow and the buffer is full,
+// the oldest result is dropped to make room for the latest.
+// It is closed when the routine exits.
+// Recovered panics are reported as a PanicError, and are still printed unless OnPanic or OnPanicStack is set.
+func (rrt *IntervalRoutine) Results() <-chan RunResult {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	if rrt.results == nil {
+		rrt.results = make(chan RunResult, resultsBufferSize)
+		if rrt.resultsClosed {
+			close(rrt.results)
+		}
+	}
+	return rrt.results
+}
+
+// publishResult sends a result to the Results channel if any, only called from the routine goroutine.
+func (rrt *IntervalRoutine) publishResult(res RunResult) {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	if rrt.results == nil {
+		return
+	}
+	select {
+	case rrt.results <- res:
+		return
+	default:
+	}
+	// full, drop the oldest
+	select {
+	case <-rrt.results:
+	default:
+	}
+	rrt.results <- res
+}
+
+// closeResults closes the Results channel when the routine exits.
+func (rrt *IntervalRoutine) closeResults() {
+	rrt.mu.Lock()
+	defer rrt.mu.Unlock()
+	rrt.resultsClosed = true
+	if rrt.results != nil {
+		close(rrt.results)
+	}
+}
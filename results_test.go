@@ -0,0 +1,84 @@
+package goodroutine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResults(t *testing.T) {
+	runErr := errors.New("error")
+	count := 0
+	f := func() error {
+		count++
+		if count == 2 {
+			return runErr
+		}
+		return nil
+	}
+	rt := NewIntervalRoutine(RunnerFunc(f), time.Hour, time.Hour)
+	results := rt.Results()
+	rt.Start()
+
+	for _, w := range []error{nil, runErr} {
+		select {
+		case res := <-results:
+			if g := res.Err; g != w {
+				t.Errorf("Result error does not match, got=%v, want=%v", g, w)
+			}
+			if res.Time.IsZero() {
+				t.Error("Result time should be set")
+			}
+		case <-time.Tick(10 * time.Millisecond):
+			t.Error("result not received")
+		}
+		rt.TriggerRun()
+	}
+
+	rt.Stop()
+	<-rt.Done()
+	for range results {
+	}
+	if _, ok := <-rt.Results(); ok {
+		t.Error("channel should be closed")
+	}
+}
+
+func TestResultsDropOldest(t *testing.T) {
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0)
+	results := rt.Results()
+	for i := 0; i < resultsBufferSize+2; i++ {
+		rt.publishResult(RunResult{Duration: time.Duration(i)})
+	}
+	if g, w := len(results), resultsBufferSize; g != w {
+		t.Errorf("Incorrect buffered results, got=%v, want=%v", g, w)
+	}
+	if g, w := (<-results).Duration, time.Duration(2); g != w {
+		t.Errorf("Oldest results should be dropped, got=%v, want=%v", g, w)
+	}
+}
+
+func TestResultsPanic(t *testing.T) {
+	errFatal := errors.New("fatal")
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		panic(errFatal)
+	}), time.Hour, 0)
+	results := rt.Results()
+	rt.Start()
+	defer rt.Stop()
+
+	select {
+	case res := <-results:
+		var pe *PanicError
+		if !errors.As(res.Err, &pe) {
+			t.Fatalf("Result should be a PanicError, got=%v", res.Err)
+		}
+		if pe.Value != errFatal || !errors.Is(res.Err, errFatal) {
+			t.Errorf("Panic value should unwrap, got=%v", pe.Value)
+		}
+	case <-time.Tick(10 * time.Millisecond):
+		t.Error("result not received")
+	}
+}
@@ -0,0 +1,39 @@
+package goodroutine
+
+import (
+	"context"
+	"time"
+)
+
+// WithInnerRetry returns a Runner calling runner up to attempts times per run, waiting delay in between,
+// until it returns no error. The last error is returned if all attempts fail, so that the routine retry applies.
+// If runner implements RunnerCtx it is given the run context, and the wait ends early when it is cancelled.
+// A panic in runner is not recovered, it propagates to the routine.
+func WithInnerRetry(runner Runner, attempts int, delay time.Duration) Runner {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return RunnerCtxFunc(func(ctx context.Context) error {
+		var err error
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				}
+			}
+			if rc, ok := runner.(RunnerCtx); ok {
+				err = rc.IntervalRunCtx(ctx)
+			} else {
+				err = runner.IntervalRun()
+			}
+			if err == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
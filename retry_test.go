@@ -0,0 +1,48 @@
+package goodroutine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithInnerRetry(t *testing.T) {
+	runErr := errors.New("error")
+	calls := 0
+	failures := 2
+	runner := WithInnerRetry(RunnerFunc(func() error {
+		calls++
+		if calls <= failures {
+			return runErr
+		}
+		return nil
+	}), 3, time.Millisecond)
+
+	if err := runner.IntervalRun(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if g, w := calls, 3; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+
+	calls = 0
+	failures = 5
+	if g, w := runner.IntervalRun(), runErr; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+	if g, w := calls, 3; g != w {
+		t.Errorf("Incorrect calls, got=%v, want=%v", g, w)
+	}
+}
+
+func TestWithInnerRetryPanic(t *testing.T) {
+	rt := NewIntervalRoutine(WithInnerRetry(RunnerFunc(func() error {
+		panic("blah")
+	}), 3, 0), time.Hour, 0)
+	rt.OnPanic = func(recovered interface{}) {}
+	panicked, err := rt.run(rt.runner)
+	var pe *PanicError
+	if !panicked || !errors.As(err, &pe) {
+		t.Errorf("Panic should propagate to the routine, got=%v, %v", panicked, err)
+	}
+}
@@ -0,0 +1,26 @@
+package goodroutine
+
+import "time"
+
+// Routine is the common interface of the routines of this package.
+// It is the recommended type for code depending on a routine, so that tests can substitute a fake.
+type Routine interface {
+	Start() bool
+	Stop()
+	TriggerRun()
+	IsRunning() bool
+	Stopped() bool
+	Done() <-chan struct{}
+	Name() string
+	CurrentInterval() time.Duration
+	LastRunTime() time.Time
+	LastErr() error
+}
+
+var (
+	_ Routine = (*IntervalRoutine)(nil)
+	_ Routine = (*FileChangeRoutine)(nil)
+	_ Routine = (*ConcurrentRoutine)(nil)
+	_ Routine = (*HealthCheckRoutine)(nil)
+	_ Routine = (*checkerRoutine)(nil)
+)
@@ -0,0 +1,81 @@
+package goodroutine
+
+import "time"
+
+// schedule is a secondary periodic function run by an IntervalRoutine.
+type schedule struct {
+	runner   Runner
+	interval time.Duration
+	current  time.Duration
+	failures int
+	next     time.Time
+}
+
+// AddSchedule adds a secondary function, run every interval from the same goroutine as the main function.
+// It shares the panic recovery and lifecycle of the routine, and is first run one interval after Start.
+// On error it is retried at the routine's retry interval, with backoff up to its own interval.
+// Runs of the main function and of schedules never overlap, a long run delays the others.
+// interval must be positive, otherwise the function is ignored.
+// This function must be called prior to calling Start()
+func (rrt *IntervalRoutine) AddSchedule(interval time.Duration, f func() error) {
+	if interval <= 0 {
+		return
+	}
+	rrt.schedules = append(rrt.schedules, &schedule{
+		runner:   RunnerFunc(f),
+		interval: interval,
+		current:  interval,
+	})
+}
+
+func (rrt *IntervalRoutine) startSchedules() {
+	now := time.Now()
+	for _, sc := range rrt.schedules {
+		sc.next = now.Add(sc.interval)
+	}
+}
+
+// nextSchedule returns the schedule due first, nil if none.
+func (rrt *IntervalRoutine) nextSchedule() *schedule {
+	var first *schedule
+	for _, sc := range rrt.schedules {
+		if first == nil || sc.next.Before(first.next) {
+			first = sc
+		}
+	}
+	return first
+}
+
+// runSchedules runs all the schedules that are due.
+func (rrt *IntervalRoutine) runSchedules() {
+	for _, sc := range rrt.schedules {
+		if time.Now().Before(sc.next) {
+			continue
+		}
+		_, err := rrt.run(sc.runner)
+		rrt.mu.RLock()
+		retry := rrt.retryInterval
+		rrt.mu.RUnlock()
+		sc.reschedule(err, retry, !rrt.RetryBackoffDisabled)
+	}
+}
+
+// reschedule sets up the next run based on the outcome of the last one.
+func (sc *schedule) reschedule(err error, retry time.Duration, backoff bool) {
+	if err == nil || retry <= 0 || retry >= sc.interval {
+		sc.failures = 0
+		sc.current = sc.interval
+	} else {
+		sc.failures++
+		if sc.failures == 1 || !backoff {
+			sc.current = retry
+		} else {
+			// backoff, starting from retry, up to interval
+			sc.current *= 2
+			if sc.current > sc.interval {
+				sc.current = sc.interval
+			}
+		}
+	}
+	sc.next = time.Now().Add(sc.current)
+}
@@ -0,0 +1,56 @@
+package goodroutine
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAddSchedule(t *testing.T) {
+	var main, light, full int32
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		atomic.AddInt32(&main, 1)
+		return nil
+	}), 100*time.Millisecond, 0)
+	rt.AddSchedule(10*time.Millisecond, func() error {
+		atomic.AddInt32(&light, 1)
+		return nil
+	})
+	rt.AddSchedule(40*time.Millisecond, func() error {
+		atomic.AddInt32(&full, 1)
+		panic("blah")
+	})
+	rt.OnPanic = func(recovered interface{}) {}
+	rt.Start()
+	time.Sleep(95 * time.Millisecond)
+	rt.Stop()
+	<-rt.Done()
+
+	// schedules do not delay the main interval
+	if g, w := atomic.LoadInt32(&main), int32(1); g != w {
+		t.Errorf("Incorrect main runs, got=%v, want=%v", g, w)
+	}
+	if g := atomic.LoadInt32(&light); g < 6 || g > 9 {
+		t.Errorf("Incorrect light runs, got=%v", g)
+	}
+	if g, w := atomic.LoadInt32(&full), int32(2); g != w {
+		t.Errorf("Incorrect full runs, got=%v, want=%v", g, w)
+	}
+}
+
+func TestScheduleBackoff(t *testing.T) {
+	sc := &schedule{interval: time.Second, current: time.Second}
+	err := errors.New("error")
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		sc.reschedule(err, 100*time.Millisecond, true)
+		if g, w := sc.current, w*time.Millisecond; g != w {
+			t.Errorf("Interval does not match at i=%d, got=%v, want=%v", i, g, w)
+		}
+	}
+	sc.reschedule(nil, 100*time.Millisecond, true)
+	if g, w := sc.current, time.Second; g != w {
+		t.Errorf("Interval does not match, got=%v, want=%v", g, w)
+	}
+}
@@ -0,0 +1,300 @@
+package goodroutine
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scheduler runs many periodic functions from a single goroutine and timer, ordered by a min-heap of
+// next run times, e.g. for fleets of hundreds of small tasks where a goroutine and timer per IntervalRoutine is wasteful.
+// Functions share the panic recovery and lifecycle of the scheduler, and keep the semantics of a routine:
+// they run right away, then every interval, are retried with backoff on error and can be triggered.
+// Runs never overlap, a long run delays the others, so functions should be short.
+// For CPU-bound or slow functions, spread them over several schedulers, up to runtime.GOMAXPROCS(0),
+// or use dedicated routines.
+type Scheduler struct {
+	mu      sync.Mutex
+	entries scheduledHeap
+	wake    chan struct{}
+	done    chan bool
+	exited  chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	start   sync.Once
+	stop    sync.Once
+
+	// PanicRecoverDisabled if set to true, panics are not recovered and crash the program
+	PanicRecoverDisabled bool
+	// OnPanic is called with the value of a recovered panic, the stack trace is printed if not set.
+	// The panic counts as a failed run, see PanicError.
+	OnPanic func(recovered interface{})
+	// RetryBackoffDisabled if set to true, failed runs are retried at the retry interval without backoff
+	RetryBackoffDisabled bool
+}
+
+// ScheduledRoutine is a function run by a Scheduler, see Scheduler.Add.
+type ScheduledRoutine struct {
+	s         *Scheduler
+	sc        schedule
+	retry     time.Duration
+	index     int
+	running   bool
+	triggered bool
+	removed   bool
+	lastRun   time.Time
+	lastErr   error
+}
+
+// NewScheduler creates a new Scheduler, functions are run once it is started.
+func NewScheduler() *Scheduler {
+	s := &Scheduler{
+		wake:   make(chan struct{}, 1),
+		done:   make(chan bool),
+		exited: make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Add adds a function run every interval, it is first run right away, or on Start.
+// Intervals are equivalent to IntervalRoutine: on error it is retried at the retry interval, with backoff up to interval.
+// A non-positive interval means the function only runs on TriggerRun.
+// If runner implements RunnerCtx, it is given a context that is cancelled on Stop.
+// It may be called before or after Start.
+func (s *Scheduler) Add(runner Runner, interval time.Duration, retry time.Duration) *ScheduledRoutine {
+	sr := &ScheduledRoutine{
+		s:     s,
+		retry: retry,
+		sc: schedule{
+			runner:   runner,
+			interval: interval,
+			current:  interval,
+		},
+	}
+	if interval > 0 {
+		sr.sc.next = time.Now()
+	}
+	s.mu.Lock()
+	heap.Push(&s.entries, sr)
+	s.mu.Unlock()
+	s.notify()
+	return sr
+}
+
+// Start the scheduler goroutine.
+// It returns true if this call started the scheduler, false if it was already started or stopped.
+func (s *Scheduler) Start() bool {
+	started := false
+	s.start.Do(func() {
+		started = true
+		go s.loop()
+	})
+	return started
+}
+
+// Stop the scheduler, a run in progress finishes but no other run starts.
+// If called before Start, functions never run: Start becomes a no-op and Done is closed right away.
+func (s *Scheduler) Stop() {
+	s.stop.Do(func() {
+		close(s.done)
+		s.cancel()
+	})
+	s.start.Do(func() {
+		// never started
+		close(s.exited)
+	})
+}
+
+// Done returns a channel that is closed once the scheduler goroutine has exited.
+func (s *Scheduler) Done() <-chan struct{} {
+	return s.exited
+}
+
+// notify wakes up the scheduler goroutine to look at the next run time again.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.exited)
+	for {
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		s.mu.Lock()
+		if len(s.entries) > 0 && !s.entries[0].sc.next.IsZero() {
+			timer = time.NewTimer(time.Until(s.entries[0].sc.next))
+			timerC = timer.C
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-timerC:
+		case <-s.wake:
+		case <-s.done:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !s.runDue() {
+			return
+		}
+	}
+}
+
+// runDue runs the functions that are due, it returns false if stopped meanwhile.
+func (s *Scheduler) runDue() bool {
+	for {
+		select {
+		case <-s.done:
+			return false
+		default:
+		}
+		s.mu.Lock()
+		if len(s.entries) == 0 || s.entries[0].sc.next.IsZero() || time.Now().Before(s.entries[0].sc.next) {
+			s.mu.Unlock()
+			return true
+		}
+		sr := heap.Pop(&s.entries).(*ScheduledRoutine)
+		sr.running = true
+		sr.triggered = false
+		s.mu.Unlock()
+
+		err := s.run(sr.sc.runner)
+
+		s.mu.Lock()
+		sr.running = false
+		sr.lastRun = time.Now()
+		sr.lastErr = err
+		if sr.sc.interval > 0 {
+			sr.sc.reschedule(err, sr.retry, !s.RetryBackoffDisabled)
+		} else {
+			sr.sc.next = time.Time{}
+		}
+		if sr.triggered {
+			// triggered during the run
+			sr.sc.next = time.Now()
+		}
+		if !sr.removed {
+			heap.Push(&s.entries, sr)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// run runs a function, recovering panics as a PanicError.
+func (s *Scheduler) run(runner Runner) (err error) {
+	if !s.PanicRecoverDisabled {
+		defer func() {
+			if r := recover(); r != nil {
+				pe := recoveredPanic(r)
+				err = pe
+				if s.OnPanic != nil {
+					s.OnPanic(pe.Value)
+				} else {
+					fmt.Printf("recovered: %v, stack: %s\n", pe.Value, pe.Stack)
+				}
+			}
+		}()
+	}
+	if rc, ok := runner.(RunnerCtx); ok {
+		return rc.IntervalRunCtx(s.ctx)
+	}
+	return runner.IntervalRun()
+}
+
+// TriggerRun triggers a run as soon as possible.
+// Triggers are coalesced like IntervalRoutine.TriggerRun.
+func (sr *ScheduledRoutine) TriggerRun() {
+	s := sr.s
+	s.mu.Lock()
+	if sr.removed {
+		s.mu.Unlock()
+		return
+	}
+	if sr.running {
+		sr.triggered = true
+	} else {
+		sr.sc.next = time.Now()
+		heap.Fix(&s.entries, sr.index)
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Remove removes the function from the scheduler, a run in progress finishes.
+func (sr *ScheduledRoutine) Remove() {
+	s := sr.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sr.removed {
+		return
+	}
+	sr.removed = true
+	if !sr.running {
+		heap.Remove(&s.entries, sr.index)
+	}
+}
+
+// CurrentInterval returns the current interval of the function, which is the retry interval after errors.
+func (sr *ScheduledRoutine) CurrentInterval() time.Duration {
+	sr.s.mu.Lock()
+	defer sr.s.mu.Unlock()
+	return sr.sc.current
+}
+
+// LastRunTime returns the time the last run completed, zero if none.
+func (sr *ScheduledRoutine) LastRunTime() time.Time {
+	sr.s.mu.Lock()
+	defer sr.s.mu.Unlock()
+	return sr.lastRun
+}
+
+// LastErr returns the error of the last run, nil if none or successful.
+func (sr *ScheduledRoutine) LastErr() error {
+	sr.s.mu.Lock()
+	defer sr.s.mu.Unlock()
+	return sr.lastErr
+}
+
+// scheduledHeap is a min-heap of functions by next run time, functions only run on trigger last.
+type scheduledHeap []*ScheduledRoutine
+
+func (h scheduledHeap) Len() int {
+	return len(h)
+}
+
+func (h scheduledHeap) Less(i, j int) bool {
+	ni, nj := h[i].sc.next, h[j].sc.next
+	if ni.IsZero() || nj.IsZero() {
+		return !ni.IsZero() && nj.IsZero()
+	}
+	return ni.Before(nj)
+}
+
+func (h scheduledHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduledHeap) Push(x interface{}) {
+	sr := x.(*ScheduledRoutine)
+	sr.index = len(*h)
+	*h = append(*h, sr)
+}
+
+func (h *scheduledHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	sr := old[n-1]
+	old[n-1] = nil
+	sr.index = -1
+	*h = old[:n-1]
+	return sr
+}
@@ -0,0 +1,88 @@
+package goodroutine
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler()
+	var fast, slow, triggered int32
+	s.Add(RunnerFunc(func() error {
+		atomic.AddInt32(&fast, 1)
+		return nil
+	}), 5*time.Millisecond, 0)
+	s.Add(RunnerFunc(func() error {
+		atomic.AddInt32(&slow, 1)
+		return nil
+	}), time.Hour, 0)
+	onTrigger := s.Add(RunnerFunc(func() error {
+		atomic.AddInt32(&triggered, 1)
+		return nil
+	}), 0, 0)
+	s.Start()
+	defer s.Stop()
+
+	onTrigger.TriggerRun()
+	timeout := time.After(time.Second)
+	for atomic.LoadInt32(&fast) < 5 || atomic.LoadInt32(&triggered) < 1 {
+		select {
+		case <-timeout:
+			t.Fatalf("Functions were not run, fast=%v, triggered=%v", atomic.LoadInt32(&fast), atomic.LoadInt32(&triggered))
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+	if g, w := atomic.LoadInt32(&slow), int32(1); g != w {
+		t.Errorf("Incorrect slow runs, got=%v, want=%v", g, w)
+	}
+	if g, w := atomic.LoadInt32(&triggered), int32(1); g != w {
+		t.Errorf("Incorrect triggered runs, got=%v, want=%v", g, w)
+	}
+
+	s.Stop()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Error("Scheduler did not exit")
+	}
+}
+
+func TestSchedulerRetryAndPanic(t *testing.T) {
+	s := NewScheduler()
+	var recovered interface{}
+	s.OnPanic = func(r interface{}) {
+		recovered = r
+	}
+	runErr := errors.New("error")
+	failing := s.Add(RunnerFunc(func() error {
+		return runErr
+	}), time.Hour, time.Minute)
+	panicking := s.Add(RunnerFunc(func() error {
+		panic("boom")
+	}), time.Hour, time.Minute)
+
+	// run synchronously
+	s.runDue()
+	if g, w := failing.LastErr(), runErr; g != w {
+		t.Errorf("Incorrect error, got=%v, want=%v", g, w)
+	}
+	if g, w := failing.CurrentInterval(), time.Minute; g != w {
+		t.Errorf("Incorrect interval, got=%v, want=%v", g, w)
+	}
+	var pe *PanicError
+	if !errors.As(panicking.LastErr(), &pe) || recovered != "boom" {
+		t.Errorf("Incorrect panic, got=%v, %v", panicking.LastErr(), recovered)
+	}
+
+	panicking.Remove()
+	failing.TriggerRun()
+	s.runDue()
+	if g, w := failing.CurrentInterval(), 2*time.Minute; g != w {
+		t.Errorf("Incorrect backoff, got=%v, want=%v", g, w)
+	}
+	if g, w := len(s.entries), 1; g != w {
+		t.Errorf("Incorrect entries, got=%v, want=%v", g, w)
+	}
+}
@@ -0,0 +1,79 @@
+package goodroutine
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RoutineStatus is a consistent copy of the status of an IntervalRoutine, see IntervalRoutine.Status.
+type RoutineStatus struct {
+	Name  string
+	State RoutineState
+	// Runs is the number of runs, and Errors the number of runs that returned an error or panicked
+	Runs   int64
+	Errors int64
+	// ConsecutiveErrors is the number of errors since the last success, driving the retry backoff
+	ConsecutiveErrors int
+	// LastRunTime is the end time of the last run, and LastDuration its duration
+	LastRunTime  time.Time
+	LastDuration time.Duration
+	// LastErr is the error of the last run, nil on success
+	LastErr error
+	// CurrentInterval is the current interval, including any retry backoff
+	CurrentInterval time.Duration
+	// NextRunTime is the time of the next scheduled run, zero if none is scheduled, e.g. while running
+	NextRunTime time.Time
+}
+
+// MarshalJSON implements json.Marshaler, durations are formatted like "5m0s".
+func (rs RoutineStatus) MarshalJSON() ([]byte, error) {
+	lastError := ""
+	if rs.LastErr != nil {
+		lastError = rs.LastErr.Error()
+	}
+	return json.Marshal(struct {
+		Name              string    `json:"name,omitempty"`
+		State             string    `json:"state"`
+		Runs              int64     `json:"runs"`
+		Errors            int64     `json:"errors"`
+		ConsecutiveErrors int       `json:"consecutiveErrors"`
+		LastRunTime       time.Time `json:"lastRunTime"`
+		LastDuration      string    `json:"lastDuration"`
+		LastError         string    `json:"lastError,omitempty"`
+		CurrentInterval   string    `json:"currentInterval"`
+		NextRunTime       time.Time `json:"nextRunTime"`
+	}{
+		Name:              rs.Name,
+		State:             rs.State.String(),
+		Runs:              rs.Runs,
+		Errors:            rs.Errors,
+		ConsecutiveErrors: rs.ConsecutiveErrors,
+		LastRunTime:       rs.LastRunTime,
+		LastDuration:      rs.LastDuration.String(),
+		LastError:         lastError,
+		CurrentInterval:   rs.CurrentInterval.String(),
+		NextRunTime:       rs.NextRunTime,
+	})
+}
+
+// Status returns the status of the routine, the run fields are captured under the routine lock.
+// A run is published to OnEvent and Results once its next run is scheduled, so a Status read from there is consistent.
+// A concurrent call may see a run recorded in LastErr and Runs before ConsecutiveErrors, CurrentInterval and NextRunTime
+// are updated for it. State is read just before, so a concurrent transition, e.g. to StateStopping, may not be reflected yet.
+func (rrt *IntervalRoutine) Status() RoutineStatus {
+	state := rrt.State()
+	rrt.mu.RLock()
+	defer rrt.mu.RUnlock()
+	return RoutineStatus{
+		Name:              rrt.name,
+		State:             state,
+		Runs:              rrt.runs,
+		Errors:            rrt.errorsTotal,
+		ConsecutiveErrors: rrt.consecutiveErrors,
+		LastRunTime:       rrt.lastRun,
+		LastDuration:      rrt.lastDuration,
+		LastErr:           rrt.lastErr,
+		CurrentInterval:   rrt.currentInterval,
+		NextRunTime:       rrt.nextRun,
+	}
+}
@@ -0,0 +1,134 @@
+package goodroutine
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	runErr := errors.New("error")
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return runErr
+	}), time.Hour, time.Minute)
+	rt.SetName("cleanup")
+	idle := make(chan bool, 2)
+	rt.OnLoopIdle = func() {
+		idle <- true
+	}
+	rt.Start()
+	defer rt.Stop()
+	// idle again after the first run
+	<-idle
+	<-idle
+
+	st := rt.Status()
+	if st.Name != "cleanup" || st.State != StateRunning || st.Runs != 1 || st.Errors != 1 || st.ConsecutiveErrors != 1 {
+		t.Errorf("Incorrect status, got=%+v", st)
+	}
+	if st.LastErr != runErr || st.CurrentInterval != time.Minute || st.NextRunTime.IsZero() {
+		t.Errorf("Incorrect status, got=%+v", st)
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, w := range []string{`"state":"running"`, `"lastError":"error"`, `"currentInterval":"1m0s"`} {
+		if !strings.Contains(string(data), w) {
+			t.Errorf("JSON should contain %v, got=%s", w, data)
+		}
+	}
+}
+
+func TestStatusRunAgain(t *testing.T) {
+	runs := 0
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		runs++
+		if runs <= 3 {
+			return ErrRunAgain
+		}
+		return nil
+	}), time.Hour, time.Minute)
+	results := rt.Results()
+	idle := make(chan bool, 5)
+	rt.OnLoopIdle = func() {
+		idle <- true
+	}
+	rt.Start()
+	defer rt.Stop()
+	// idle again after the fourth run
+	for i := 0; i < 5; i++ {
+		<-idle
+	}
+
+	st := rt.Status()
+	if st.Runs != 4 || st.Errors != 0 || st.LastErr != nil {
+		t.Errorf("Incorrect status, got=%+v", st)
+	}
+	for i := 0; i < 4; i++ {
+		if res := <-results; res.Err != nil {
+			t.Errorf("Incorrect result, got=%v", res.Err)
+		}
+	}
+}
+
+func TestStatusWhileRunning(t *testing.T) {
+	running := make(chan bool)
+	release := make(chan bool)
+	runs := 0
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		runs++
+		if runs > 1 {
+			running <- true
+			<-release
+		}
+		return nil
+	}), time.Hour, time.Minute)
+	idle := make(chan bool, 2)
+	rt.OnLoopIdle = func() {
+		idle <- true
+	}
+	rt.Start()
+	defer rt.Stop()
+	// idle again after the first run
+	<-idle
+	<-idle
+	if rt.Status().NextRunTime.IsZero() {
+		t.Error("Next run should be scheduled after the first run")
+	}
+
+	rt.TriggerRun()
+	<-running
+	if g := rt.Status().NextRunTime; !g.IsZero() {
+		t.Errorf("Incorrect next run while running, got=%v, want zero", g)
+	}
+	close(release)
+	<-idle
+	if rt.Status().NextRunTime.IsZero() {
+		t.Error("Next run should be scheduled after the run")
+	}
+}
+
+func TestStatusFromOnEvent(t *testing.T) {
+	runErr := errors.New("error")
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return runErr
+	}), time.Hour, time.Minute)
+	statuses := make(chan RoutineStatus, 1)
+	rt.OnEvent = func(ev Event) {
+		statuses <- rt.Status()
+	}
+	rt.Start()
+	defer rt.Stop()
+
+	st := <-statuses
+	if st.Runs != 1 || st.LastErr != runErr || st.ConsecutiveErrors != 1 {
+		t.Errorf("Incorrect status, got=%+v", st)
+	}
+	if st.CurrentInterval != time.Minute || st.NextRunTime.IsZero() {
+		t.Errorf("Status should include the next run, got=%+v", st)
+	}
+}
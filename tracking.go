@@ -0,0 +1,61 @@
+package goodroutine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// routine tracking, a diagnostics feature to find routines never stopped, e.g. in tests
+var (
+	trackingEnabled int32
+	trackedMu       sync.Mutex
+	tracked         = make(map[*IntervalRoutine]struct{})
+)
+
+// TrackRoutines enables or disables the tracking of running routines, disabled by default.
+// When enabled, routines started afterwards are tracked until their goroutine exits, see ActiveRoutines.
+// This is a diagnostics feature to detect leaks, e.g. at the end of tests, not a safety net:
+// a routine never stopped is reported, not stopped. It has no cost when disabled.
+func TrackRoutines(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&trackingEnabled, 1)
+	} else {
+		atomic.StoreInt32(&trackingEnabled, 0)
+	}
+}
+
+// ActiveRoutines returns the number of tracked routines whose goroutine has not exited.
+func ActiveRoutines() int {
+	trackedMu.Lock()
+	defer trackedMu.Unlock()
+	return len(tracked)
+}
+
+// ActiveRoutineNames returns the sorted names of tracked routines whose goroutine has not exited,
+// unnamed routines are reported as an empty name.
+func ActiveRoutineNames() []string {
+	trackedMu.Lock()
+	defer trackedMu.Unlock()
+	names := make([]string, 0, len(tracked))
+	for rrt := range tracked {
+		names = append(names, rrt.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// track registers a started routine if tracking is enabled, and returns the function to call on exit.
+func (rrt *IntervalRoutine) track() func() {
+	if atomic.LoadInt32(&trackingEnabled) == 0 {
+		return func() {}
+	}
+	trackedMu.Lock()
+	tracked[rrt] = struct{}{}
+	trackedMu.Unlock()
+	return func() {
+		trackedMu.Lock()
+		delete(tracked, rrt)
+		trackedMu.Unlock()
+	}
+}
@@ -0,0 +1,32 @@
+package goodroutine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackRoutines(t *testing.T) {
+	TrackRoutines(true)
+	defer TrackRoutines(false)
+
+	rt := NewIntervalRoutine(RunnerFunc(func() error {
+		return nil
+	}), time.Hour, 0)
+	rt.SetName("leaky")
+	rt.Start()
+	names := ActiveRoutineNames()
+	found := false
+	for _, name := range names {
+		found = found || name == "leaky"
+	}
+	if !found {
+		t.Errorf("Routine should be tracked, got=%v", names)
+	}
+
+	n := ActiveRoutines()
+	rt.Stop()
+	<-rt.Done()
+	if g, w := ActiveRoutines(), n-1; g != w {
+		t.Errorf("Incorrect active routines, got=%v, want=%v", g, w)
+	}
+}
@@ -0,0 +1,157 @@
+// Package trigger provides ways for external processes to trigger routine runs,
+// e.g. a control socket to reload configuration, similar to nginx -s reload.
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Triggerer is implemented by routines that can be triggered, such as goodroutine.IntervalRoutine.
+type Triggerer interface {
+	TriggerRun()
+}
+
+// UnixListener triggers runs on connections to a Unix domain socket.
+type UnixListener struct {
+	l     net.Listener
+	r     Triggerer
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	once  sync.Once
+	quit  chan struct{}
+}
+
+// ListenUnix listens on the Unix domain socket at path and calls r.TriggerRun each time data is received,
+// whatever the content, e.g. `echo | nc -U /run/app/reload.sock` or `echo | socat - UNIX-CONNECT:/run/app/reload.sock`.
+// Triggers are coalesced by the routine, so a burst of bytes causes at most one extra run.
+// Concurrent connections are served independently, each one until the peer closes it.
+// A stale socket file left by a previous process, refusing connections, is removed.
+// A socket still served by another listener, or any other existing file, is an error.
+// The socket file is removed on Close.
+//
+// Anyone able to connect to the socket can trigger runs: the socket is made accessible to its owner only (0600),
+// and should be created in a directory that is not writable by others, as the permissions are set after the socket is created.
+func ListenUnix(path string, r Triggerer) (*UnixListener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		c, err := net.Dial("unix", path)
+		if err == nil {
+			c.Close()
+			return nil, fmt.Errorf("trigger: socket %v is in use", path)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// stale socket
+			os.Remove(path)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return serve(l, r), nil
+}
+
+// serve starts accepting connections on l.
+func serve(l net.Listener, r Triggerer) *UnixListener {
+	ul := &UnixListener{
+		l:     l,
+		r:     r,
+		conns: make(map[net.Conn]struct{}),
+		quit:  make(chan struct{}),
+	}
+	ul.wg.Add(1)
+	go ul.accept()
+	return ul
+}
+
+// Addr returns the address of the socket.
+func (ul *UnixListener) Addr() net.Addr {
+	return ul.l.Addr()
+}
+
+// Close stops listening, closes open connections and removes the socket file.
+// It waits for connection handlers to return.
+func (ul *UnixListener) Close() error {
+	var err error
+	ul.once.Do(func() {
+		close(ul.quit)
+		err = ul.l.Close()
+		ul.mu.Lock()
+		for c := range ul.conns {
+			c.Close()
+		}
+		ul.conns = nil
+		ul.mu.Unlock()
+		ul.wg.Wait()
+	})
+	return err
+}
+
+// accept serves connections until Close, transient errors like EMFILE are retried with backoff.
+func (ul *UnixListener) accept() {
+	defer ul.wg.Done()
+	var delay time.Duration
+	for {
+		c, err := ul.l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			select {
+			case <-time.After(delay):
+			case <-ul.quit:
+				return
+			}
+			continue
+		}
+		delay = 0
+		ul.mu.Lock()
+		if ul.conns == nil {
+			ul.mu.Unlock()
+			c.Close()
+			return
+		}
+		ul.conns[c] = struct{}{}
+		ul.wg.Add(1)
+		ul.mu.Unlock()
+		go ul.serve(c)
+	}
+}
+
+func (ul *UnixListener) serve(c net.Conn) {
+	defer ul.wg.Done()
+	defer func() {
+		ul.mu.Lock()
+		if ul.conns != nil {
+			delete(ul.conns, c)
+		}
+		ul.mu.Unlock()
+		c.Close()
+	}()
+	buf := make([]byte, 512)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			ul.r.TriggerRun()
+		}
+		if err != nil {
+			// closed by the peer or on Close
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package trigger
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type countTriggerer struct {
+	calls int32
+}
+
+func (ct *countTriggerer) TriggerRun() {
+	atomic.AddInt32(&ct.calls, 1)
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.sock")
+	ct := &countTriggerer{}
+	ul, err := ListenUnix(path, ct)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := fi.Mode().Perm(), os.FileMode(0600); g != w {
+		t.Errorf("Incorrect permissions, got=%v, want=%v", g, w)
+	}
+
+	// concurrent connections, one left open
+	c1, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c2, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c1.Write([]byte("x"))
+	c2.Write([]byte("\n"))
+	c2.Close()
+
+	timeout := time.After(time.Second)
+	for atomic.LoadInt32(&ct.calls) < 2 {
+		select {
+		case <-timeout:
+			t.Fatalf("Routine was not triggered, calls=%v", atomic.LoadInt32(&ct.calls))
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+
+	if err := ul.Close(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Socket file should be removed, got=%v", err)
+	}
+	c1.Close()
+}
+
+func TestListenUnixExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.sock")
+	os.WriteFile(path, nil, 0600)
+	if _, err := ListenUnix(path, &countTriggerer{}); err == nil {
+		t.Error("Expected an error on an existing regular file")
+	}
+}
+
+func TestListenUnixInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.sock")
+	ct := &countTriggerer{}
+	ul, err := ListenUnix(path, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	if _, err := ListenUnix(path, &countTriggerer{}); err == nil {
+		t.Fatal("Expected an error on a socket in use")
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Socket should still be served, got=%v", err)
+	}
+	defer c.Close()
+	c.Write([]byte("\n"))
+	timeout := time.After(time.Second)
+	for atomic.LoadInt32(&ct.calls) == 0 {
+		select {
+		case <-timeout:
+			t.Fatal("Timed out waiting for trigger")
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+}
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// leave the socket file behind, as a crashed process would
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	ul, err := ListenUnix(path, &countTriggerer{})
+	if err != nil {
+		t.Fatalf("Stale socket should be replaced, got=%v", err)
+	}
+	ul.Close()
+}
+
+// flakyListener fails the first Accept calls with a transient error.
+type flakyListener struct {
+	net.Listener
+	fails int32
+}
+
+func (fl *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&fl.fails, -1) >= 0 {
+		return nil, syscall.EMFILE
+	}
+	return fl.Listener.Accept()
+}
+
+func TestListenUnixAcceptError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := &countTriggerer{}
+	ul := serve(&flakyListener{Listener: l, fails: 2}, ct)
+	defer ul.Close()
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("\n"))
+	timeout := time.After(time.Second)
+	for atomic.LoadInt32(&ct.calls) == 0 {
+		select {
+		case <-timeout:
+			t.Fatal("Listener should keep accepting after a transient error")
+		case <-time.Tick(time.Millisecond):
+		}
+	}
+}